@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	cluster "github.com/pion/ion-cluster/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminAddr   string
+	adminToken  string
+	adminOutput string
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "inspect and control a running cluster",
+	Long: `Inspect and control a running cluster. Sessions and nodes are listed from etcd when the
+config has an etcd coordinator, peers are inspected and kicked, and sessions closed, through a
+node's admin api.`,
+	SilenceUsage: true,
+}
+
+var adminSessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "list, show and close sessions",
+}
+
+var adminSessionsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list sessions",
+	Args:  cobra.NoArgs,
+	RunE:  adminSessionsList,
+}
+
+var adminSessionsShowCmd = &cobra.Command{
+	Use:   "show <session id>",
+	Short: "show a session and its peers",
+	Args:  cobra.ExactArgs(1),
+	RunE:  adminSessionsShow,
+}
+
+var adminSessionsCloseCmd = &cobra.Command{
+	Use:   "close <session id>",
+	Short: "disconnect every peer in a session, they are told the session was closed",
+	Args:  cobra.ExactArgs(1),
+	RunE:  adminSessionsClose,
+}
+
+var adminPeersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "control peers",
+}
+
+var adminPeersKickCmd = &cobra.Command{
+	Use:   "kick <session id> <peer id>",
+	Short: "disconnect a peer, it is told it was kicked",
+	Args:  cobra.ExactArgs(2),
+	RunE:  adminPeersKick,
+}
+
+var adminNodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "list and drain nodes",
+}
+
+var adminNodesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list nodes and their load",
+	Args:  cobra.NoArgs,
+	RunE:  adminNodesList,
+}
+
+var adminNodesDrainCmd = &cobra.Command{
+	Use:   "drain <node id>",
+	Short: "drain a node, it stops taking sessions and fails readyz",
+	Args:  cobra.ExactArgs(1),
+	RunE:  adminNodesDrain,
+}
+
+func init() {
+	adminCmd.PersistentFlags().StringVar(&adminAddr, "admin", "", "node admin api address (default signal.admin.addr)")
+	adminCmd.PersistentFlags().StringVar(&adminToken, "admin-token", "", "admin api bearer token (default signal.admin.token, or a jwt minted from signal.auth)")
+	adminCmd.PersistentFlags().StringVar(&tokenPrivateKey, "private-key", "", "PEM private key file to mint admin jwts for RSA and ECDSA keytypes")
+	adminCmd.PersistentFlags().StringVarP(&adminOutput, "output", "o", "table", "output format, table or json")
+
+	adminSessionsCmd.AddCommand(adminSessionsListCmd, adminSessionsShowCmd, adminSessionsCloseCmd)
+	adminPeersCmd.AddCommand(adminPeersKickCmd)
+	adminNodesCmd.AddCommand(adminNodesListCmd, adminNodesDrainCmd)
+	adminCmd.AddCommand(adminSessionsCmd, adminPeersCmd, adminNodesCmd)
+
+	rootCmd.AddCommand(adminCmd)
+}
+
+func adminClient() (*cluster.AdminClient, context.Context, context.CancelFunc, error) {
+	if adminOutput != "table" && adminOutput != "json" {
+		return nil, nil, nil, fmt.Errorf("unknown output %q, use table or json", adminOutput)
+	}
+	addr := adminAddr
+	if addr == "" {
+		addr = conf.Signal.Admin.Addr
+	}
+	a, err := cluster.NewAdminClient(conf, addr, adminAPIToken())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	return a, ctx, cancel, nil
+}
+
+// adminAPIToken is --admin-token, signal.admin.token or a short lived jwt with an admin claim
+func adminAPIToken() string {
+	if adminToken != "" {
+		return adminToken
+	}
+	if conf.Signal.Admin.Token != "" || !conf.Signal.Auth.Enabled {
+		return conf.Signal.Admin.Token
+	}
+	token, err := signToken(conf.Signal.Auth, jwt.MapClaims{
+		"admin": true,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	})
+	if err != nil {
+		// Listing from etcd works without one, the admin api will refuse the request
+		log.Info("can't mint an admin token, admin api requests will be refused", "reason", err.Error())
+		return ""
+	}
+	return token
+}
+
+// printOutput writes v as json, or as a table through table
+func printOutput(v interface{}, table func(w *tabwriter.Writer)) error {
+	if adminOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+func adminSessionsList(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	sessions, err := a.ListSessions(ctx)
+	if err != nil {
+		return err
+	}
+	return printOutput(sessions, func(w *tabwriter.Writer) {
+		if a.Cluster() {
+			fmt.Fprintln(w, "SESSION\tNODE\tENDPOINT")
+			for _, s := range sessions {
+				fmt.Fprintf(w, "%v\t%v\t%v\n", s.SessionID, s.NodeID, s.NodeEndpoint)
+			}
+			return
+		}
+		fmt.Fprintln(w, "SESSION\tNODE\tPEERS")
+		for _, s := range sessions {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", s.SessionID, s.NodeID, s.PeerCount)
+		}
+	})
+}
+
+func adminSessionsShow(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	session, err := a.GetSession(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printOutput(session, func(w *tabwriter.Writer) {
+		fmt.Fprintf(w, "SESSION\t%v\n", session.SessionID)
+		fmt.Fprintf(w, "NODE\t%v\n", session.NodeID)
+		if session.NodeEndpoint != "" {
+			fmt.Fprintf(w, "ENDPOINT\t%v\n", session.NodeEndpoint)
+		}
+		if session.Remote {
+			fmt.Fprintln(w, "\nsession is hosted on another node, pass that node's --admin address to see its peers")
+			return
+		}
+		fmt.Fprintf(w, "PEERS\t%v\n\n", session.PeerCount)
+		fmt.Fprintln(w, "PEER\tPUBLISHED\tSUBSCRIBED\tINGRESS BPS")
+		for _, p := range session.Peers {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", p.PeerID, p.PublishedTracks, p.SubscribedTracks, p.IngressBitrate)
+		}
+	})
+}
+
+func adminSessionsClose(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	if err := a.CloseSession(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("closed %v\n", args[0])
+	return nil
+}
+
+func adminPeersKick(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	if err := a.KickPeer(ctx, args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("kicked %v from %v\n", args[1], args[0])
+	return nil
+}
+
+func adminNodesList(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	nodes, err := a.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+	return printOutput(nodes, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "NODE\tENDPOINT\tSESSIONS\tPEERS\tINGRESS BPS\tFULL\tDRAINING")
+		for _, n := range nodes {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", n.NodeID, n.NodeEndpoint, n.Sessions, n.Peers, n.IngressBitrate, n.Full, n.Draining)
+		}
+	})
+}
+
+func adminNodesDrain(cmd *cobra.Command, args []string) error {
+	a, ctx, cancel, err := adminClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer a.Close()
+
+	if err := a.DrainNode(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("asked %v to drain\n", args[0])
+	return nil
+}
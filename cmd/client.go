@@ -11,8 +11,8 @@ import (
 	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
 
+	cluster "github.com/pion/ion-cluster/pkg"
 	"github.com/pion/ion-cluster/pkg/client"
-	"github.com/pion/ion-cluster/pkg/client/gst"
 	"github.com/spf13/cobra"
 )
 
@@ -20,35 +20,48 @@ var (
 	clientURL   string
 	clientSID   string
 	clientToken string
+	clientLoop  bool
+	clientFPS   int
 )
 
 var clientCmd = &cobra.Command{
-	Use:   "client",
+	Use:   "client [test | <media file>...]",
 	Short: "Connect to an ion-cluster server as a client",
-	RunE:  clientMain,
+	Long: `Connect to an ion-cluster server as a client and publish media.
+.ivf (vp8, vp9, av1), .h264 (annex-b) and .ogg (opus) files are sent as they are without
+gstreamer, pass a video and an audio file to publish both. Other files and the test
+pipeline are encoded with gstreamer.`,
+	RunE: clientMain,
 }
 
 func init() {
 	clientCmd.PersistentFlags().StringVarP(&clientURL, "url", "u", "ws://localhost:7000", "sfu host to connect to")
 	clientCmd.PersistentFlags().StringVarP(&clientSID, "sid", "s", "test-session", "session id to join")
 	clientCmd.PersistentFlags().StringVarP(&clientToken, "token", "t", "", "jwt access token")
+	clientCmd.Flags().BoolVar(&clientLoop, "loop", true, "loop .ivf, .h264 and .ogg files")
+	clientCmd.Flags().IntVar(&clientFPS, "fps", 30, "frame rate of .h264 files")
 
 	rootCmd.AddCommand(clientCmd)
 }
 
 func endpoint() string {
-	url := fmt.Sprintf("%s/session/%s", clientURL, clientSID)
-	if clientToken != "" {
-		url += fmt.Sprintf("?access_token=%s", clientToken)
+	return sessionURL(clientURL, clientSID, clientToken)
+}
+
+// sessionURL is the websocket url a client joins sid on
+func sessionURL(base, sid, token string) string {
+	url := fmt.Sprintf("%s/session/%s", base, sid)
+	if token != "" {
+		url += fmt.Sprintf("?access_token=%s", token)
 	}
 
 	return url
 }
 
 func clientMain(cmd *cobra.Command, args []string) error {
-	go clientThread(cmd, args)
-	gst.MainLoop()
-	return nil
+	return runMainLoop(func() error {
+		return clientThread(cmd, args)
+	})
 }
 
 func clientThread(cmd *cobra.Command, args []string) error {
@@ -70,6 +83,9 @@ func clientThread(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	signal.OnDisconnect(func(reason cluster.DisconnectReason) {
+		log.Info("server disconnected client", "reason", reason)
+	})
 
 	c.OnTrack = func(t *webrtc.TrackRemote, r *webrtc.RTPReceiver, pc *webrtc.PeerConnection) {
 		log.Info("Client got track: %#v", t)
@@ -103,14 +119,29 @@ func clientThread(cmd *cobra.Command, args []string) error {
 
 	log.Info("starting producer")
 
-	var producer *client.GSTProducer
+	var producer client.Producer
 	if len(args) > 0 {
-		switch args[0] {
-		case "test":
+		switch {
+		case args[0] == "test":
 			log.Info("starting video test pipeline")
-			producer = client.NewGSTProducer(c, "video", "")
+			gstProducer, err := client.NewGSTProducer(c, "video", "")
+			if err != nil {
+				return err
+			}
+			producer = gstProducer
+		case isMediaFiles(args):
+			fileProducer, err := newFileProducer(args)
+			if err != nil {
+				log.Error(err, "error opening media files")
+				return err
+			}
+			producer = fileProducer
 		default:
-			producer = client.NewGSTProducer(c, "screen", args[0])
+			gstProducer, err := client.NewGSTProducer(c, "screen", args[0])
+			if err != nil {
+				return err
+			}
+			producer = gstProducer
 		}
 	}
 
@@ -134,12 +165,47 @@ func clientThread(cmd *cobra.Command, args []string) error {
 			log.Info("signal ping got pong")
 		case sig := <-sigs:
 			log.Info("got signal", "signal", sig)
+			if err := signal.Leave(); err != nil {
+				log.Error(err, "signal leave err")
+			}
 			signal.Close()
 		case <-signalClosedCh:
-			log.Info("signal closed")
-			os.Exit(1)
-			return nil
+			log.Info("signal closed, resuming")
+			if signalClosedCh, err = signal.Resume(endpoint()); err != nil {
+				log.Error(err, "signal resume err")
+				os.Exit(1)
+				return nil
+			}
+			log.Info("signal resumed")
+		}
+	}
+
+}
+
+// isMediaFiles reports whether every file can be published without gstreamer
+func isMediaFiles(files []string) bool {
+	for _, f := range files {
+		if ok, _ := client.IsMediaFile(f); !ok {
+			return false
 		}
 	}
+	return true
+}
 
+// newFileProducer publishes up to one video and one audio file
+func newFileProducer(files []string) (*client.FileProducer, error) {
+	conf := client.FileProducerConfig{Loop: clientLoop, FrameRate: clientFPS}
+	for _, f := range files {
+		_, video := client.IsMediaFile(f)
+		switch {
+		case video && conf.Video == "":
+			conf.Video = f
+		case !video && conf.Audio == "":
+			conf.Audio = f
+		default:
+			return nil, fmt.Errorf("%v: only one video and one audio file can be published", f)
+		}
+	}
+	log.Info("publishing media files", "video", conf.Video, "audio", conf.Audio, "loop", conf.Loop)
+	return client.NewFileProducer(conf)
 }
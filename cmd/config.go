@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	cluster "github.com/pion/ion-cluster/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// minHMACKeyLength is the size of an HS256 signature, shorter keys are easier to brute force
+const minHMACKeyLength = 32
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "check and print the ion-cluster config",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:          "validate",
+	Short:        "check the config file for unknown keys and invalid settings",
+	SilenceUsage: true,
+	RunE:         configValidate,
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "print the effective config after env overrides, with secrets redacted",
+	RunE:  configPrint,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configPrintCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func configValidate(cmd *cobra.Command, args []string) error {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return fmt.Errorf("no config file found, pass one with --config")
+	}
+
+	var problems []string
+
+	// viper ignores keys that don't match a field, decode the file on its own to catch them
+	strict := viper.New()
+	strict.SetConfigFile(file)
+	strict.SetConfigType("toml")
+	if err := strict.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading %v: %w", file, err)
+	}
+	if err := strict.UnmarshalExact(&cluster.RootConfig{}); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	problems = append(problems, conf.Problems()...)
+
+	if conf.Signal.Auth.Enabled && isHMAC(conf.Signal.Auth) && len(conf.Signal.Auth.Key) < minHMACKeyLength {
+		fmt.Printf("warning: signal.auth.key is shorter than %v bytes\n", minHMACKeyLength)
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println("error:", p)
+		}
+		return fmt.Errorf("%v is invalid", file)
+	}
+
+	fmt.Println(file, "is valid")
+	return nil
+}
+
+func configPrint(cmd *cobra.Command, args []string) error {
+	flat := cluster.RedactedConfig(conf)
+
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("%v = %v\n", k, flat[k])
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build gst
+// +build gst
+
+package cmd
+
+import (
+	"github.com/pion/ion-cluster/pkg/client/gst"
+)
+
+// runMainLoop runs thread alongside the gstreamer main loop, which never returns
+func runMainLoop(thread func() error) error {
+	go func() {
+		if err := thread(); err != nil {
+			log.Error(err, "client error")
+		}
+	}()
+	gst.MainLoop()
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !gst
+// +build !gst
+
+package cmd
+
+import (
+	"github.com/pion/ion-cluster/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// runMainLoop runs thread, there is no gstreamer main loop without the gst tag
+func runMainLoop(thread func() error) error {
+	return thread()
+}
+
+func compositeMain(cmd *cobra.Command, args []string) error {
+	return client.ErrGSTNotCompiled
+}
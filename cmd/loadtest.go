@@ -0,0 +1,437 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pion/webrtc/v3"
+	"github.com/spf13/cobra"
+
+	cluster "github.com/pion/ion-cluster/pkg"
+	"github.com/pion/ion-cluster/pkg/client"
+)
+
+var (
+	loadtestURL          string
+	loadtestToken        string
+	loadtestSessions     int
+	loadtestPublishers   int
+	loadtestSubscribers  int
+	loadtestSessionName  string
+	loadtestRamp         float64
+	loadtestDuration     time.Duration
+	loadtestVideoBitrate int
+	loadtestAudioBitrate int
+	loadtestFrameRate    int
+	loadtestTokenExpiry  time.Duration
+)
+
+var errLoadtestDisconnected = errors.New("disconnected before the test ended")
+
+var loadtestCmd = &cobra.Command{
+	Use:   "loadtest",
+	Short: "join many simulated peers publishing synthetic media and report how the cluster held up",
+	Long: `Join many simulated peers from one process. Publishers send generated h264 and opus
+at the configured bitrates without encoding anything, every peer subscribes to the session.
+Tokens are minted per session from signal.auth when it is enabled in the config. A token
+passed with --token joins the single session in its sid claim, --session-prefix is unused.`,
+	SilenceUsage: true,
+	RunE:         loadtestMain,
+}
+
+func init() {
+	loadtestCmd.Flags().StringVarP(&loadtestURL, "url", "u", "ws://localhost:7000", "sfu host to connect to")
+	loadtestCmd.Flags().StringVarP(&loadtestToken, "token", "t", "", "jwt access token used by every peer, they join the session in its sid claim so only one session is run")
+	loadtestCmd.Flags().StringVar(&tokenPrivateKey, "private-key", "", "PEM private key file to mint tokens for RSA and ECDSA keytypes")
+	loadtestCmd.Flags().IntVar(&loadtestSessions, "sessions", 1, "number of sessions")
+	loadtestCmd.Flags().IntVar(&loadtestPublishers, "publishers", 1, "publishing peers per session")
+	loadtestCmd.Flags().IntVar(&loadtestSubscribers, "subscribers", 4, "subscribe only peers per session")
+	loadtestCmd.Flags().StringVar(&loadtestSessionName, "session-prefix", "loadtest", "session ids are <prefix>-<n>, give each loadtest process its own prefix")
+	loadtestCmd.Flags().Float64Var(&loadtestRamp, "ramp", 10, "peers started per second, 0 starts every peer at once")
+	loadtestCmd.Flags().DurationVarP(&loadtestDuration, "duration", "d", time.Minute, "how long to run once every peer has started")
+	loadtestCmd.Flags().IntVar(&loadtestVideoBitrate, "video-bitrate", 500000, "synthetic video bitrate in bits per second")
+	loadtestCmd.Flags().IntVar(&loadtestAudioBitrate, "audio-bitrate", 32000, "synthetic audio bitrate in bits per second")
+	loadtestCmd.Flags().IntVar(&loadtestFrameRate, "fps", 30, "synthetic video frame rate")
+	loadtestCmd.Flags().DurationVar(&loadtestTokenExpiry, "token-expiry", 0, "lifetime of minted tokens, 0 covers the ramp and duration with an hour to spare")
+
+	rootCmd.AddCommand(loadtestCmd)
+}
+
+// loadPeer is one simulated peer and what it measured
+type loadPeer struct {
+	sid     string
+	publish bool
+
+	joinLatency time.Duration
+	err         error
+
+	mu       sync.Mutex
+	joinedAt time.Time
+	leftAt   time.Time
+	tracks   map[string]*loadTrack
+}
+
+// loadTrack counts the rtp received on one track, sequence numbers are extended
+// past their 16 bit wrap to count the packets that never arrived
+type loadTrack struct {
+	bytes    uint64
+	packets  uint64
+	firstSeq int64
+	lastSeq  int64
+}
+
+func loadtestMain(cmd *cobra.Command, args []string) error {
+	if loadtestSessions < 1 || loadtestPublishers+loadtestSubscribers < 1 {
+		return fmt.Errorf("need at least one session with one peer")
+	}
+
+	perSession := loadtestPublishers + loadtestSubscribers
+	total := loadtestSessions * perSession
+
+	var interval time.Duration
+	if loadtestRamp > 0 {
+		interval = time.Duration(float64(time.Second) / loadtestRamp)
+	}
+
+	expiry := loadtestTokenExpiry
+	if expiry <= 0 {
+		expiry = time.Duration(total)*interval + loadtestDuration + time.Hour
+	}
+
+	sids, tokens, err := loadtestSessionTokens(expiry)
+	if err != nil {
+		return err
+	}
+
+	// Peers keep ctx until they have left, an interrupt only ends the ramp and the wait
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	stop := make(chan struct{})
+
+	log.Info("starting load test", "sessions", loadtestSessions, "peers", total, "ramp", loadtestRamp)
+
+	var wg sync.WaitGroup
+	peers := make([]*loadPeer, 0, total)
+	started := time.Now()
+	ramped := rampPeers(interrupted.Done(), total, interval, func(i int) {
+		p := &loadPeer{sid: sids[i/perSession], publish: i%perSession < loadtestPublishers, tracks: make(map[string]*loadTrack)}
+		peers = append(peers, p)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.run(ctx, sessionURL(loadtestURL, p.sid, tokens[p.sid]), stop)
+		}()
+	})
+
+	if ramped {
+		log.Info("every peer started, running", "peers", len(peers), "rampTime", time.Since(started), "duration", loadtestDuration)
+		select {
+		case <-time.After(loadtestDuration):
+		case <-interrupted.Done():
+			log.Info("interrupted, stopping peers")
+		}
+	} else {
+		log.Info("interrupted while ramping, stopping peers", "peers", len(peers))
+	}
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		log.Info("peers didn't all leave in time, reporting anyway")
+	}
+	cancel()
+
+	printLoadtestReport(peers)
+	return nil
+}
+
+// loadtestSessionTokens names the sessions and gives each its token. A --token is bound to the
+// session in its sid claim, so it is the only session, otherwise tokens are minted per session
+// from signal.auth when it is enabled.
+func loadtestSessionTokens(expiry time.Duration) ([]string, map[string]string, error) {
+	tokens := make(map[string]string)
+	if loadtestToken != "" {
+		sid, err := tokenSessionID(loadtestToken)
+		if err != nil {
+			return nil, nil, err
+		}
+		if loadtestSessions > 1 {
+			return nil, nil, fmt.Errorf("--token only joins session %q, it can't be used with --sessions %v, leave it out to mint a token per session from signal.auth", sid, loadtestSessions)
+		}
+		tokens[sid] = loadtestToken
+		return []string{sid}, tokens, nil
+	}
+
+	sids := make([]string, 0, loadtestSessions)
+	for i := 0; i < loadtestSessions; i++ {
+		sid := fmt.Sprintf("%v-%v", loadtestSessionName, i)
+		sids = append(sids, sid)
+		if !conf.Signal.Auth.Enabled {
+			continue
+		}
+		token, err := signToken(conf.Signal.Auth, jwt.MapClaims{
+			"sid": sid,
+			"exp": time.Now().Add(expiry).Unix(),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		tokens[sid] = token
+	}
+	return sids, tokens, nil
+}
+
+// tokenSessionID reads the sid claim of a jwt without verifying it, the sfu does that
+func tokenSessionID(token string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return "", fmt.Errorf("can't read --token: %v", err)
+	}
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return "", errors.New("--token has no sid claim")
+	}
+	return sid, nil
+}
+
+// rampPeers calls start for peers 0 to n-1, interval apart, returns false if interrupted is
+// closed before every peer has started
+func rampPeers(interrupted <-chan struct{}, n int, interval time.Duration, start func(i int)) bool {
+	for i := 0; i < n; i++ {
+		select {
+		case <-interrupted:
+			return false
+		default:
+		}
+
+		start(i)
+
+		if interval > 0 && i < n-1 {
+			select {
+			case <-time.After(interval):
+			case <-interrupted:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *loadPeer) run(ctx context.Context, url string, stop <-chan struct{}) {
+	start := time.Now()
+	sig := client.NewJSONRPCSignalClient(ctx)
+	c, err := client.NewClient(sig, &webrtc.Configuration{}, nil)
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	defer c.Close()
+
+	closed, err := sig.Open(url)
+	if err != nil {
+		p.fail(err)
+		return
+	}
+	defer sig.Close()
+
+	disconnected := make(chan cluster.DisconnectReason, 1)
+	sig.OnDisconnect(func(reason cluster.DisconnectReason) {
+		select {
+		case disconnected <- reason:
+		default:
+		}
+	})
+	c.OnTrack = func(track *webrtc.TrackRemote, recv *webrtc.RTPReceiver, pc *webrtc.PeerConnection) {
+		go p.readTrack(track)
+	}
+
+	if err := c.Join(p.sid); err != nil {
+		p.fail(err)
+		return
+	}
+	p.mu.Lock()
+	p.joinLatency = time.Since(start)
+	p.joinedAt = time.Now()
+	p.mu.Unlock()
+
+	if p.publish {
+		producer, err := client.NewSyntheticProducer(client.SyntheticConfig{
+			VideoBitrate: loadtestVideoBitrate,
+			AudioBitrate: loadtestAudioBitrate,
+			FrameRate:    loadtestFrameRate,
+		})
+		if err != nil {
+			p.fail(err)
+			return
+		}
+		defer producer.Stop()
+		if err := c.Publish(producer); err != nil {
+			p.fail(err)
+			return
+		}
+	}
+
+	select {
+	case <-stop:
+		if err := sig.Leave(); err != nil {
+			log.Error(err, "load test peer leave error", "sessionID", p.sid)
+		}
+	case reason := <-disconnected:
+		p.fail(fmt.Errorf("server disconnected peer: %v", reason))
+	case <-closed:
+		p.fail(errLoadtestDisconnected)
+	}
+
+	p.mu.Lock()
+	p.leftAt = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *loadPeer) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *loadPeer) readTrack(track *webrtc.TrackRemote) {
+	t := &loadTrack{firstSeq: -1}
+	p.mu.Lock()
+	p.tracks[track.ID()] = t
+	p.mu.Unlock()
+
+	for {
+		pkt, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		t.add(pkt.SequenceNumber, len(pkt.Payload))
+		p.mu.Unlock()
+	}
+}
+
+// add counts a received packet
+func (t *loadTrack) add(sequenceNumber uint16, size int) {
+	seq := int64(sequenceNumber)
+	if t.firstSeq < 0 {
+		t.firstSeq, t.lastSeq = seq, seq
+	} else {
+		// Extend the sequence number to the value closest to the last one
+		cycle := t.lastSeq &^ 0xffff
+		ext := cycle | seq
+		if ext-t.lastSeq > 0x8000 {
+			ext -= 0x10000
+		} else if t.lastSeq-ext > 0x8000 {
+			ext += 0x10000
+		}
+		if ext > t.lastSeq {
+			t.lastSeq = ext
+		}
+	}
+	t.packets++
+	t.bytes += uint64(size)
+}
+
+// received is the bitrate and loss over the time the peer was in its session
+func (p *loadPeer) received() (bitrate float64, loss float64, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	left := p.leftAt
+	if left.IsZero() {
+		left = time.Now()
+	}
+	seconds := left.Sub(p.joinedAt).Seconds()
+	if p.joinedAt.IsZero() || seconds <= 0 || len(p.tracks) == 0 {
+		return 0, 0, false
+	}
+
+	var bytes, packets, expected uint64
+	for _, t := range p.tracks {
+		if t.firstSeq < 0 {
+			continue
+		}
+		bytes += t.bytes
+		packets += t.packets
+		expected += uint64(t.lastSeq-t.firstSeq) + 1
+	}
+	if expected > 0 && packets < expected {
+		loss = float64(expected-packets) / float64(expected) * 100
+	}
+	return float64(bytes) * 8 / seconds, loss, true
+}
+
+func printLoadtestReport(peers []*loadPeer) {
+	var joins, bitrates, losses []float64
+	failures := make(map[string]int)
+	failed := 0
+	for _, p := range peers {
+		p.mu.Lock()
+		err, latency := p.err, p.joinLatency
+		p.mu.Unlock()
+
+		if latency > 0 {
+			joins = append(joins, latency.Seconds()*1000)
+		}
+		if err != nil {
+			failed++
+			failures[err.Error()]++
+		}
+		if bitrate, loss, ok := p.received(); ok {
+			bitrates = append(bitrates, bitrate/1000)
+			losses = append(losses, loss)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("peers:         %v started, %v joined, %v failed (%.1f%%)\n",
+		len(peers), len(joins), failed, float64(failed)/float64(len(peers))*100)
+	printPercentiles("join ms:      ", joins)
+	printPercentiles("recv kbps:    ", bitrates)
+	printPercentiles("packet loss %:", losses)
+
+	if len(failures) > 0 {
+		fmt.Println("failures:")
+		for reason, n := range failures {
+			fmt.Printf("  %5d  %v\n", n, reason)
+		}
+	}
+}
+
+func printPercentiles(label string, values []float64) {
+	if len(values) == 0 {
+		fmt.Println(label, "no samples")
+		return
+	}
+	sort.Float64s(values)
+	fmt.Printf("%v min %.1f  p50 %.1f  p90 %.1f  p99 %.1f  max %.1f\n", label,
+		values[0], percentile(values, 50), percentile(values, 90), percentile(values, 99), values[len(values)-1])
+}
+
+// percentile of sorted values by nearest rank
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
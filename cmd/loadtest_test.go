@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	for _, tc := range []struct {
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{sorted, 0, 1},
+		{sorted, 10, 1},
+		{sorted, 11, 2},
+		{sorted, 50, 5},
+		{sorted, 90, 9},
+		{sorted, 99, 10},
+		{sorted, 100, 10},
+		{[]float64{42}, 50, 42},
+		{[]float64{42}, 99, 42},
+	} {
+		if got := percentile(tc.values, tc.p); got != tc.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tc.values, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestLoadTrack(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		seqs     []uint16
+		expected int64
+	}{
+		{"in order", []uint16{10, 11, 12}, 3},
+		{"gap", []uint16{10, 11, 14}, 5},
+		{"reordered", []uint16{10, 12, 11, 13}, 4},
+		{"wrap", []uint16{65534, 65535, 0, 1}, 4},
+		{"gap across wrap", []uint16{65534, 65535, 1}, 4},
+		{"late packets across wrap", []uint16{65534, 1, 65535, 0, 2}, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			track := &loadTrack{firstSeq: -1}
+			for _, seq := range tc.seqs {
+				track.add(seq, 100)
+			}
+			if got := track.lastSeq - track.firstSeq + 1; got != tc.expected {
+				t.Errorf("expected %v packets, got %v", tc.expected, got)
+			}
+			if track.packets != uint64(len(tc.seqs)) || track.bytes != uint64(100*len(tc.seqs)) {
+				t.Errorf("counted %v packets %v bytes", track.packets, track.bytes)
+			}
+		})
+	}
+}
+
+func TestRampPeers(t *testing.T) {
+	t.Run("every peer", func(t *testing.T) {
+		var started []int
+		if !rampPeers(make(chan struct{}), 5, time.Millisecond, func(i int) { started = append(started, i) }) {
+			t.Fatal("ramp reported an interrupt")
+		}
+		if len(started) != 5 || started[4] != 4 {
+			t.Errorf("started %v", started)
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		interrupted := make(chan struct{})
+		started := 0
+		begin := time.Now()
+		// Interrupted during the first hour long interval
+		ok := rampPeers(interrupted, 100, time.Hour, func(i int) {
+			started++
+			if i == 0 {
+				close(interrupted)
+			}
+		})
+		if ok {
+			t.Fatal("ramp wasn't interrupted")
+		}
+		if started != 1 {
+			t.Errorf("started %v peers, want 1", started)
+		}
+		if elapsed := time.Since(begin); elapsed > time.Second {
+			t.Errorf("interrupted ramp took %v", elapsed)
+		}
+	})
+
+	t.Run("interrupted before start", func(t *testing.T) {
+		interrupted := make(chan struct{})
+		close(interrupted)
+		if rampPeers(interrupted, 5, 0, func(i int) { t.Errorf("started peer %v", i) }) {
+			t.Fatal("ramp wasn't interrupted")
+		}
+	})
+}
+
+func TestLoadtestSessionTokens(t *testing.T) {
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	roomToken := sign(jwt.MapClaims{"sid": "room"})
+
+	defer func(token, prefix string, sessions int) {
+		loadtestToken, loadtestSessionName, loadtestSessions = token, prefix, sessions
+	}(loadtestToken, loadtestSessionName, loadtestSessions)
+	loadtestSessionName = "lt"
+
+	for _, tc := range []struct {
+		name     string
+		token    string
+		sessions int
+		wantSIDs []string
+		wantErr  bool
+	}{
+		{"prefixed sessions", "", 3, []string{"lt-0", "lt-1", "lt-2"}, false},
+		{"token joins its sid", roomToken, 1, []string{"room"}, false},
+		{"token with many sessions", roomToken, 2, nil, true},
+		{"token without sid", sign(jwt.MapClaims{"admin": true}), 1, nil, true},
+		{"not a token", "garbage", 1, nil, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loadtestToken, loadtestSessions = tc.token, tc.sessions
+			sids, tokens, err := loadtestSessionTokens(time.Hour)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(sids, tc.wantSIDs) {
+				t.Errorf("sids = %v, want %v", sids, tc.wantSIDs)
+			}
+			if tc.token != "" && !tc.wantErr && tokens[tc.wantSIDs[0]] != tc.token {
+				t.Errorf("token for %v = %q, want --token", tc.wantSIDs[0], tokens[tc.wantSIDs[0]])
+			}
+		})
+	}
+}
@@ -141,12 +141,14 @@ func initConfig() {
 	viper.SetEnvPrefix("ION")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	bindConfigEnvs(conf)
+	viper.SetDefault("log.v", 1)
 
 	err := viper.GetViper().Unmarshal(&conf)
 	if err != nil {
 		log.Error(err, "sfu config file loaded failed. %v\n", "cfg", cfgFile)
 		os.Exit(1)
 	}
+	cluster.SetLogVerbosity(conf.Log.V)
 
 	if len(conf.SFU.WebRTC.ICEPortRange) > 2 {
 		log.Error(err, "config file %s loaded failed. range port must be [min,max]\n", cfgFile)
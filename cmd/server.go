@@ -1,17 +1,23 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	cluster "github.com/pion/ion-cluster/pkg"
-	"github.com/pion/ion-sfu/pkg/sfu"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
+// shutdownTimeout bounds waiting on open http requests once clients have left
+const shutdownTimeout = 10 * time.Second
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "start an ion-cluster server node",
@@ -30,6 +36,19 @@ func init() {
 func serverMain(cmd *cobra.Command, args []string) error {
 
 	log.Info("--- Starting SFU Node ---")
+	shutdownTracing, err := cluster.InitTracing(conf.Tracing)
+	if err != nil {
+		log.Error(err, "error initializing tracing")
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Error(err, "error flushing traces")
+		}
+	}()
+
 	coordinator, err := cluster.NewCoordinator(conf)
 	if err != nil {
 		log.Error(err, "error creating coordinator")
@@ -44,15 +63,37 @@ func serverMain(cmd *cobra.Command, args []string) error {
 	if conf.Signal.HTTPAddr != "" {
 		go sServer.ServeWebsocket()
 	}
+	if conf.Signal.Admin.Addr != "" {
+		go sServer.ServeAdmin()
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := sServer.Shutdown(ctx); err != nil {
+			log.Error(err, "error shutting down http server")
+		}
+	}()
 
 	if conf.SFU.Turn.Enabled {
-		_, err := sfu.InitTurnServer(conf.SFU.Turn, nil)
+		_, err := cluster.InitTurnServer(conf.SFU.Turn)
 		log.Info("Started TURN Server", "listen", conf.SFU.Turn.Address)
 		if err != nil {
 			log.Error(err, "Could not init turn server")
 			return err
 		}
+		sServer.AddReadinessCheck("turn", cluster.TURNHealthCheck(conf.SFU.Turn.Address, conf.SFU.Turn.Cert != "" && conf.SFU.Turn.Key != ""))
+	}
+
+	// Reload on config file changes and SIGHUP, both are read here so viper is never read
+	// from two goroutines at once
+	reloads := make(chan struct{}, 1)
+	if viper.ConfigFileUsed() != "" {
+		if err := watchConfigFile(viper.ConfigFileUsed(), reloads); err != nil {
+			log.Error(err, "error watching config file, reload with SIGHUP", "file", viper.ConfigFileUsed())
+		}
 	}
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
 
 	// Listen for signals
 	sigs := make(chan os.Signal, 1)
@@ -64,8 +105,15 @@ func serverMain(cmd *cobra.Command, args []string) error {
 		case err := <-sError:
 			log.Error(err, "Error in wsServer")
 			return err
+		case <-hups:
+			log.Info("Got SIGHUP, reloading config")
+			reloadConfig(sServer)
+		case <-reloads:
+			log.Info("config file changed, reloading config", "file", viper.ConfigFileUsed())
+			reloadConfig(sServer)
 		case sig := <-sigs:
 			log.Info("Got signal, beginning shutdown", "signal", sig)
+			sServer.Drain()
 			ticker := time.NewTicker(500 * time.Millisecond)
 			for {
 				active := cluster.MetricsGetActiveClientsCount()
@@ -85,3 +133,93 @@ func serverMain(cmd *cobra.Command, args []string) error {
 		}
 	}
 }
+
+// watchConfigFile sends on changed when the config file is written or replaced. The directory
+// is watched so editors and kubernetes configmaps that swap the file for a new one are seen too.
+func watchConfigFile(path string, changed chan<- struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file := filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+	realFile, _ := filepath.EvalSymlinks(file)
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if !written && (current == "" || current == realFile) {
+					continue
+				}
+				realFile = current
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error(err, "error watching config file", "file", file)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadConfig reads the config file and applies its runtime reloadable settings, changes
+// to anything else are logged and need a restart
+func reloadConfig(s *cluster.Signal) {
+	if err := viper.ReadInConfig(); err != nil {
+		log.Error(err, "error reading config, keeping the current config")
+		return
+	}
+	next := cluster.RootConfig{}
+	if err := viper.Unmarshal(&next); err != nil {
+		log.Error(err, "error parsing config, keeping the current config")
+		return
+	}
+	// Discovered at startup rather than configured
+	if len(next.SFU.WebRTC.Candidates.NAT1To1IPs) == 0 {
+		next.SFU.WebRTC.Candidates.NAT1To1IPs = conf.SFU.WebRTC.Candidates.NAT1To1IPs
+	}
+	// Filled in by the server flags when the config doesn't set them, so they aren't changes
+	if !viper.IsSet("signal.httpaddr") {
+		next.Signal.HTTPAddr = conf.Signal.HTTPAddr
+	}
+	if !viper.IsSet("signal.cert") {
+		next.Signal.Cert = conf.Signal.Cert
+	}
+	if !viper.IsSet("signal.key") {
+		next.Signal.Key = conf.Signal.Key
+	}
+	if err := next.Validate(); err != nil {
+		log.Error(err, "refusing config reload, keeping the current config")
+		return
+	}
+
+	reloadable, restart := cluster.DiffConfig(conf, next)
+	for _, c := range restart {
+		log.Info("config change needs a restart, ignoring", "change", c.String())
+	}
+	if len(reloadable) == 0 {
+		log.Info("no reloadable config changes")
+		return
+	}
+	for _, c := range reloadable {
+		log.Info("applying config change", "change", c.String())
+	}
+
+	conf = conf.WithReloadable(next)
+	s.Reload(conf)
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	cluster "github.com/pion/ion-cluster/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenSID        string
+	tokenUID        string
+	tokenExpiry     time.Duration
+	tokenClaims     []string
+	tokenPrivateKey string
+	tokenURL        string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "mint a jwt access token for a session using the configured signal.auth",
+	Long: `Mint a jwt access token for a session using the configured signal.auth.
+HMAC tokens are signed with signal.auth.key, RSA and ECDSA tokens with --private-key,
+the private half of the public key in signal.auth.key.
+
+The server only checks sid and exp, and admin for the admin api. --uid and --claim
+are carried in the token for services that read it, they don't change what the
+token is allowed to do on the server.`,
+	SilenceUsage: true,
+	RunE:         tokenMain,
+}
+
+func init() {
+	tokenCmd.Flags().StringVarP(&tokenSID, "sid", "s", "", "session id the token is valid for")
+	tokenCmd.Flags().StringVar(&tokenUID, "uid", "", "peer id to put in the token, not checked by the server")
+	tokenCmd.Flags().DurationVarP(&tokenExpiry, "expiry", "e", 24*time.Hour, "time until the token expires, 0 never expires")
+	tokenCmd.Flags().StringArrayVar(&tokenClaims, "claim", nil, "extra claim as key=value, e.g. --claim role=host, repeatable, not checked by the server")
+	tokenCmd.Flags().StringVar(&tokenPrivateKey, "private-key", "", "PEM private key file for RSA and ECDSA keytypes")
+	tokenCmd.Flags().StringVarP(&tokenURL, "url", "u", "", "sfu host for the printed client url (default from signal.fqdn and signal.httpaddr)")
+	tokenCmd.MarkFlagRequired("sid")
+
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func tokenMain(cmd *cobra.Command, args []string) error {
+	claims := jwt.MapClaims{
+		"sid": tokenSID,
+		"iat": time.Now().Unix(),
+	}
+	if tokenUID != "" {
+		claims["uid"] = tokenUID
+	}
+	if tokenExpiry > 0 {
+		claims["exp"] = time.Now().Add(tokenExpiry).Unix()
+	}
+	for _, c := range tokenClaims {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("claim %q must be key=value", c)
+		}
+		claims[kv[0]] = claimValue(kv[1])
+	}
+
+	token, err := signToken(conf.Signal.Auth, claims)
+	if err != nil {
+		return err
+	}
+
+	if !conf.Signal.Auth.Enabled {
+		log.Info("signal.auth is disabled in the config, servers using it won't check the token")
+	}
+
+	fmt.Println(token)
+
+	base := tokenURL
+	if base == "" && conf.Signal.FQDN != "" && strings.Contains(conf.Signal.HTTPAddr, ":") {
+		endpoint, err := url.Parse(conf.Endpoint())
+		if err != nil {
+			return err
+		}
+		base = fmt.Sprintf("%v://%v", endpoint.Scheme, endpoint.Host)
+	}
+	if base != "" {
+		fmt.Println(sessionURL(base, tokenSID, token))
+		fmt.Printf("ion-cluster client -u %v -s %v -t %v\n", base, tokenSID, token)
+	}
+	return nil
+}
+
+// signToken signs claims with signal.auth.key, or --private-key for RSA and ECDSA
+func signToken(auth cluster.AuthConfig, claims jwt.MapClaims) (string, error) {
+	method, key, err := tokenSigningKey(auth)
+	if err != nil {
+		return "", err
+	}
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return token, nil
+}
+
+// tokenSigningKey picks the signing method and key for the configured keytype
+func tokenSigningKey(auth cluster.AuthConfig) (jwt.SigningMethod, interface{}, error) {
+	if isHMAC(auth) {
+		if tokenPrivateKey != "" {
+			return nil, nil, fmt.Errorf("--private-key is only used with RSA and ECDSA keytypes, signal.auth.keytype is %q", auth.KeyType)
+		}
+		if auth.Key == "" {
+			return nil, nil, fmt.Errorf("signal.auth.key is empty")
+		}
+		return jwt.SigningMethodHS256, []byte(auth.Key), nil
+	}
+
+	if tokenPrivateKey == "" {
+		return nil, nil, fmt.Errorf("signal.auth.keytype %q needs --private-key", auth.KeyType)
+	}
+	pem, err := ioutil.ReadFile(tokenPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch strings.ToUpper(auth.KeyType) {
+	case "RSA":
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %v: %w", tokenPrivateKey, err)
+		}
+		return jwt.SigningMethodRS256, key, nil
+	case "ECDSA":
+		key, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %v: %w", tokenPrivateKey, err)
+		}
+		switch key.Curve.Params().BitSize {
+		case 384:
+			return jwt.SigningMethodES384, key, nil
+		case 521:
+			return jwt.SigningMethodES512, key, nil
+		default:
+			return jwt.SigningMethodES256, key, nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported signal.auth.keytype %q", auth.KeyType)
+	}
+}
+
+func isHMAC(auth cluster.AuthConfig) bool {
+	return auth.KeyType == "" || strings.EqualFold(auth.KeyType, "HMAC")
+}
+
+// claimValue keeps booleans and numbers typed in the token, only "true" and "false" are
+// booleans so "1" and "0" stay numbers
+func claimValue(v string) interface{} {
+	switch v {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
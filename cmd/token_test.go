@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClaimValue(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want interface{}
+	}{
+		{"true", true},
+		{"false", false},
+		{"1", int64(1)},
+		{"0", int64(0)},
+		{"-42", int64(-42)},
+		{"1.5", 1.5},
+		{"t", "t"},
+		{"f", "f"},
+		{"TRUE", "TRUE"},
+		{"host", "host"},
+		{"", ""},
+	} {
+		if got := claimValue(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("claimValue(%q) = %#v, want %#v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -19,6 +19,7 @@ require (
 	github.com/coreos/etcd v3.3.25+incompatible
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/envoyproxy/go-control-plane v0.9.4 // indirect
+	github.com/fsnotify/fsnotify v1.5.1
 	github.com/getlantern/deepcopy v0.0.0-20160317154340-7f45deb8130a
 	github.com/go-logr/logr v1.2.0
 	github.com/gorilla/mux v1.8.0
@@ -27,13 +28,19 @@ require (
 	github.com/lucsky/cuid v1.0.2
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pborman/uuid v1.2.1
+	github.com/pion/dtls/v2 v2.1.2
 	github.com/pion/interceptor v0.1.7
 	github.com/pion/ion-log v1.0.1
 	github.com/pion/ion-sfu v1.10.7
+	github.com/pion/logging v0.2.2
 	github.com/pion/quic v0.1.4 // indirect
 	github.com/pion/rtcp v1.2.9
 	github.com/pion/sdp/v2 v2.4.0
 	github.com/pion/srtp v1.5.2 // indirect
+	github.com/pion/stun v0.3.5
+	github.com/pion/transport v0.13.0
+	github.com/pion/turn/v2 v2.0.6
+	github.com/pion/udp v0.1.1
 	github.com/pion/webrtc/v3 v3.1.23
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/prometheus/client_golang v1.9.0
@@ -43,6 +50,11 @@ require (
 	github.com/spf13/cobra v1.1.1
 	github.com/spf13/viper v1.7.1
 	go.etcd.io/etcd v3.3.25+incompatible // indirect
+	go.opentelemetry.io/otel v1.3.0
+	go.opentelemetry.io/otel/sdk v1.3.0
+	go.opentelemetry.io/otel/trace v1.3.0
+	go.opentelemetry.io/proto/otlp v0.11.0
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 	google.golang.org/grpc v1.35.0
 	k8s.io/api v0.20.4
 	k8s.io/apimachinery v0.20.4
@@ -0,0 +1,167 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionInfo a session and where it is hosted, Peers is only known to the hosting node
+type SessionInfo struct {
+	SessionID    string     `json:"session_id"`
+	NodeID       string     `json:"node_id"`
+	NodeEndpoint string     `json:"node_endpoint,omitempty"`
+	PeerCount    int        `json:"peer_count"`
+	Peers        []PeerInfo `json:"peers,omitempty"`
+	// Remote is set when the session was found in etcd on a node other than the admin api's
+	Remote bool `json:"remote,omitempty"`
+}
+
+// PeerInfo a peer in a session
+type PeerInfo struct {
+	PeerID           string `json:"peer_id"`
+	PublishedTracks  int    `json:"published_tracks"`
+	SubscribedTracks int    `json:"subscribed_tracks"`
+	// IngressBitrate of the peer's published tracks in bits per second
+	IngressBitrate uint64 `json:"ingress_bitrate"`
+}
+
+func sessionInfo(nodeID string, session *Session, withPeers bool) SessionInfo {
+	info := SessionInfo{
+		SessionID: session.ID(),
+		NodeID:    nodeID,
+	}
+
+	peers := session.Peers()
+	info.PeerCount = len(peers)
+	if !withPeers {
+		return info
+	}
+
+	streamIDs := session.publishedStreamIDs()
+	for _, peer := range peers {
+		p := PeerInfo{PeerID: peer.ID()}
+		if peer.Publisher() != nil {
+			for _, recv := range peer.Publisher().GetRouter().GetReceiver() {
+				p.PublishedTracks++
+				for _, bitrate := range recv.GetBitrate() {
+					p.IngressBitrate += bitrate
+				}
+			}
+		}
+		if peer.Subscriber() != nil {
+			for _, streamID := range streamIDs {
+				p.SubscribedTracks += len(peer.Subscriber().GetDownTracks(streamID))
+			}
+		}
+		info.Peers = append(info.Peers, p)
+	}
+	sort.Slice(info.Peers, func(i, j int) bool { return info.Peers[i].PeerID < info.Peers[j].PeerID })
+	return info
+}
+
+// noBrowsers refuses requests sent by browsers, which carry an Origin, so web pages can't
+// reach the admin listener through a user's browser
+func noBrowsers(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminAuth requires the admin token, or a signal.auth jwt with an admin claim, for h
+func (s *Signal) adminAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := authAdminRequest(s.conf(), r); err != nil {
+			log.V(1).Info("rejecting admin api request", "path", r.URL.Path, "reason", err.Error())
+			if err == errAdminAuthDisabled {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// nodeHandler serves this node's load
+func (s *Signal) nodeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		load := s.c.load()
+		load.Draining = s.isDraining()
+		writeJSON(w, load)
+	})
+}
+
+// drainHandler drains this node, the same as a SIGTERM without exiting once clients have left
+func (s *Signal) drainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Drain()
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// sessionsHandler lists the sessions on this node
+func sessionsHandler(c coordinator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessions := make([]SessionInfo, 0)
+		for _, session := range c.getLocalSessions() {
+			sessions = append(sessions, sessionInfo(c.getNodeID(), session, false))
+		}
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+		writeJSON(w, sessions)
+	})
+}
+
+// sessionHandler shows a session on this node with its peers
+func sessionHandler(c coordinator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := localSession(c, mux.Vars(r)["sid"])
+		if session == nil {
+			http.Error(w, "session not found on this node", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, sessionInfo(c.getNodeID(), session, true))
+	})
+}
+
+// closeSessionHandler disconnects every peer in a session with the session_closed reason
+func closeSessionHandler(c coordinator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid := mux.Vars(r)["sid"]
+		session := localSession(c, sid)
+		if session == nil {
+			http.Error(w, "session not found on this node", http.StatusNotFound)
+			return
+		}
+		session.DisconnectAll(DisconnectReasonSessionClosed)
+		log.Info("closed session through the admin api", "sessionID", sid)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// kickPeerHandler disconnects a peer with the kicked reason
+func kickPeerHandler(c coordinator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		session := localSession(c, vars["sid"])
+		if session == nil || !session.DisconnectPeer(vars["uid"], DisconnectReasonKicked) {
+			http.Error(w, "peer not found on this node", http.StatusNotFound)
+			return
+		}
+		log.Info("kicked peer through the admin api", "sessionID", vars["sid"], "peerID", vars["uid"])
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
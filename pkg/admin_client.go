@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"google.golang.org/grpc"
+)
+
+// AdminClient inspects and controls a running cluster. Cluster wide views come from the
+// etcd coordinator when one is configured, node local details from a node's admin api.
+type AdminClient struct {
+	etcd  *clientv3.Client
+	admin string
+	token string
+	http  *http.Client
+}
+
+// NewAdminClient connects to the etcd coordinator in conf, if any, and the admin api
+// at adminAddr, a host:port or url, authenticating with token
+func NewAdminClient(conf RootConfig, adminAddr, token string) (*AdminClient, error) {
+	a := &AdminClient{
+		admin: adminURL(adminAddr),
+		token: token,
+		http:  &http.Client{Timeout: 10 * time.Second},
+	}
+
+	if conf.Coordinator.Etcd != nil {
+		cli, err := clientv3.New(clientv3.Config{
+			DialTimeout: time.Second * 3,
+			DialOptions: []grpc.DialOption{grpc.WithBlock()},
+			Endpoints:   conf.Coordinator.Etcd.Hosts,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("connecting to etcd: %w", err)
+		}
+		a.etcd = cli
+	}
+	return a, nil
+}
+
+// adminURL turns a listen address like :7100 into a url for it
+func adminURL(addr string) string {
+	if addr == "" || strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	if host, port, err := net.SplitHostPort(addr); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return "http://" + addr
+}
+
+// Cluster is true when sessions and nodes are listed from etcd, which doesn't know peer counts
+func (a *AdminClient) Cluster() bool {
+	return a.etcd != nil
+}
+
+// Close closes the etcd connection
+func (a *AdminClient) Close() error {
+	if a.etcd != nil {
+		return a.etcd.Close()
+	}
+	return nil
+}
+
+// ListSessions lists every session in the cluster, or on the admin api's node without etcd
+func (a *AdminClient) ListSessions(ctx context.Context) ([]SessionInfo, error) {
+	if a.etcd == nil {
+		var sessions []SessionInfo
+		err := a.do(ctx, http.MethodGet, "/admin/sessions", &sessions)
+		return sessions, err
+	}
+
+	gr, err := a.etcd.Get(ctx, "/session/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	return etcdSessions(gr.Kvs)
+}
+
+// etcdSessions decodes the session metas listed under /session/, skipping the keys below
+// them, sorted by session id
+func etcdSessions(kvs []*mvccpb.KeyValue) ([]SessionInfo, error) {
+	sessions := make([]SessionInfo, 0, len(kvs))
+	for _, kv := range kvs {
+		// The session locks live under /session/{id}/
+		if strings.Count(string(kv.Key), "/") != 2 {
+			continue
+		}
+		var meta sessionMeta
+		if err := json.Unmarshal(kv.Value, &meta); err != nil {
+			return nil, fmt.Errorf("decoding %v: %w", string(kv.Key), err)
+		}
+		sessions = append(sessions, SessionInfo{
+			SessionID:    meta.SessionID,
+			NodeID:       meta.NodeID,
+			NodeEndpoint: meta.NodeEndpoint,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+	return sessions, nil
+}
+
+// GetSession shows a session with its peers when it is on the admin api's node,
+// otherwise the node hosting it from etcd
+func (a *AdminClient) GetSession(ctx context.Context, sid string) (*SessionInfo, error) {
+	var info SessionInfo
+	err := a.do(ctx, http.MethodGet, "/admin/sessions/"+url.PathEscape(sid), &info)
+	if err == nil {
+		return &info, nil
+	}
+	if a.etcd == nil {
+		return nil, err
+	}
+
+	gr, etcdErr := a.etcd.Get(ctx, "/session/"+sid)
+	if etcdErr != nil {
+		return nil, etcdErr
+	}
+	if gr.Count == 0 {
+		return nil, fmt.Errorf("session %v not found", sid)
+	}
+	var meta sessionMeta
+	if err := json.Unmarshal(gr.Kvs[0].Value, &meta); err != nil {
+		return nil, err
+	}
+	return &SessionInfo{
+		SessionID:    meta.SessionID,
+		NodeID:       meta.NodeID,
+		NodeEndpoint: meta.NodeEndpoint,
+		Remote:       true,
+	}, nil
+}
+
+// CloseSession disconnects every peer in a session on the admin api's node
+func (a *AdminClient) CloseSession(ctx context.Context, sid string) error {
+	return a.do(ctx, http.MethodDelete, "/admin/sessions/"+url.PathEscape(sid), nil)
+}
+
+// KickPeer disconnects a peer on the admin api's node
+func (a *AdminClient) KickPeer(ctx context.Context, sid, uid string) error {
+	return a.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/sessions/%v/peers/%v", url.PathEscape(sid), url.PathEscape(uid)), nil)
+}
+
+// ListNodes lists the load every node advertises, or the admin api's node without etcd
+func (a *AdminClient) ListNodes(ctx context.Context) ([]NodeLoad, error) {
+	if a.etcd == nil {
+		var load NodeLoad
+		if err := a.do(ctx, http.MethodGet, "/admin/node", &load); err != nil {
+			return nil, err
+		}
+		return []NodeLoad{load}, nil
+	}
+
+	gr, err := a.etcd.Get(ctx, "/node/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]NodeLoad, 0, len(gr.Kvs))
+	for _, kv := range gr.Kvs {
+		var load NodeLoad
+		if err := json.Unmarshal(kv.Value, &load); err != nil {
+			return nil, fmt.Errorf("decoding %v: %w", string(kv.Key), err)
+		}
+		nodes = append(nodes, load)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].NodeID < nodes[j].NodeID })
+	return nodes, nil
+}
+
+// DrainNode asks a node to drain through etcd, or through the admin api without etcd
+func (a *AdminClient) DrainNode(ctx context.Context, nodeID string) error {
+	if a.etcd == nil {
+		var load NodeLoad
+		if err := a.do(ctx, http.MethodGet, "/admin/node", &load); err != nil {
+			return err
+		}
+		if nodeID != load.NodeID {
+			return fmt.Errorf("node %v not found, the admin api at %v is node %v", nodeID, a.admin, load.NodeID)
+		}
+		return a.do(ctx, http.MethodPost, "/admin/node/drain", nil)
+	}
+
+	gr, err := a.etcd.Get(ctx, "/node/"+nodeID)
+	if err != nil {
+		return err
+	}
+	if gr.Count == 0 {
+		return fmt.Errorf("node %v not found", nodeID)
+	}
+	lease, err := a.etcd.Grant(ctx, drainRequestTTL)
+	if err != nil {
+		return err
+	}
+	_, err = a.etcd.Put(ctx, drainRequestKey(nodeID), time.Now().UTC().Format(time.RFC3339), clientv3.WithLease(lease.ID))
+	return err
+}
+
+// do calls the admin api, decoding the response into v when it is not nil
+func (a *AdminClient) do(ctx context.Context, method, path string, v interface{}) error {
+	if a.admin == "" {
+		return fmt.Errorf("no admin api address, set signal.admin.addr or pass one")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, a.admin+path, nil)
+	if err != nil {
+		return err
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%v %v: %v %v", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestAdminURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		"":                   "",
+		":7100":              "http://127.0.0.1:7100",
+		"0.0.0.0:7100":       "http://127.0.0.1:7100",
+		"[::]:7100":          "http://127.0.0.1:7100",
+		"10.0.0.1:7100":      "http://10.0.0.1:7100",
+		"admin.local:7100":   "http://admin.local:7100",
+		"http://admin:7100/": "http://admin:7100",
+		"https://admin":      "https://admin",
+	} {
+		if got := adminURL(addr); got != want {
+			t.Errorf("adminURL(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	adminJWT := func(admin bool) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &adminToken{
+			Admin:          admin,
+			StandardClaims: &jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		auth   bool
+		header map[string]string
+		want   int
+	}{
+		{"auth disabled", "", false, map[string]string{"Authorization": "Bearer admin"}, http.StatusForbidden},
+		{"no bearer", "admin", false, nil, http.StatusUnauthorized},
+		{"wrong token", "admin", false, map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"token", "admin", false, map[string]string{"Authorization": "Bearer admin"}, http.StatusOK},
+		{"jwt without auth", "admin", false, map[string]string{"Authorization": "Bearer " + adminJWT(true)}, http.StatusUnauthorized},
+		{"admin jwt", "", true, map[string]string{"Authorization": "Bearer " + adminJWT(true)}, http.StatusOK},
+		{"jwt without admin claim", "", true, map[string]string{"Authorization": "Bearer " + adminJWT(false)}, http.StatusUnauthorized},
+		{"browser", "admin", false, map[string]string{"Authorization": "Bearer admin", "Origin": "https://example.com"}, http.StatusForbidden},
+	} {
+		var conf RootConfig
+		conf.Signal.Admin.Token = tc.token
+		conf.Signal.Auth = AuthConfig{Enabled: tc.auth, Key: "secret"}
+		s, _ := testSignal(t, conf, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/node", nil)
+		for k, v := range tc.header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.adminHandler().ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%v: /admin/node = %v %v, want %v", tc.name, w.Code, strings.TrimSpace(w.Body.String()), tc.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%v: WWW-Authenticate = %q, want Bearer", tc.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestAdminClient(t *testing.T) {
+	var conf RootConfig
+	conf.Signal.Admin.Token = "admin"
+	s, srv := testSignal(t, conf, nil)
+	admin := httptest.NewServer(s.adminHandler())
+	defer admin.Close()
+
+	p := dialTestPeer(t, srv, "admin", nil)
+	if err := p.join("admin", "a"); err != nil {
+		t.Fatal(err)
+	}
+	testSessionPeer(t, s, "admin")
+
+	ctx := context.Background()
+	unauthorized, err := NewAdminClient(RootConfig{}, admin.URL, "nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.ListSessions(ctx); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("list sessions with a wrong token = %v, want 401", err)
+	}
+
+	a, err := NewAdminClient(RootConfig{}, admin.URL+"/", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := a.ListSessions(ctx)
+	if err != nil || len(sessions) != 1 || sessions[0].SessionID != "admin" || sessions[0].PeerCount != 1 {
+		t.Errorf("sessions = %+v, %v, want admin with 1 peer", sessions, err)
+	}
+	info, err := a.GetSession(ctx, "admin")
+	if err != nil || len(info.Peers) != 1 {
+		t.Errorf("session = %+v, %v, want its peer", info, err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		sid, uid string
+		wantErr  string
+	}{
+		{"missing session", "missing", "a", "404"},
+		{"missing peer", "admin", "b", "404"},
+		{"peer", "admin", "a", ""},
+		{"kicked peer", "admin", "a", "404"},
+	} {
+		err := a.KickPeer(ctx, tc.sid, tc.uid)
+		if (tc.wantErr == "" && err != nil) || (tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr))) {
+			t.Errorf("%v: kick = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+	waitFor(t, "kick disconnect", func() bool { return len(p.notified("disconnect")) > 0 })
+	var d Disconnect
+	if err := json.Unmarshal(p.notified("disconnect")[0], &d); err != nil || d.Reason != DisconnectReasonKicked {
+		t.Errorf("disconnect = %+v, %v, want %v", d, err, DisconnectReasonKicked)
+	}
+
+	if err := a.CloseSession(ctx, "missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("close a missing session = %v, want 404", err)
+	}
+
+	noAddr, _ := NewAdminClient(RootConfig{}, "", "admin")
+	if _, err := noAddr.ListNodes(ctx); err == nil {
+		t.Error("list nodes without an admin address succeeded")
+	}
+}
+
+func TestEtcdSessions(t *testing.T) {
+	kv := func(key, value string) *mvccpb.KeyValue {
+		return &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value)}
+	}
+
+	got, err := etcdSessions([]*mvccpb.KeyValue{
+		kv("/session/b", `{"session_id": "b", "node_id": "n2", "node_endpoint": "ws://n2:7000"}`),
+		kv("/session/b/694d7a2b", "lock"),
+		kv("/session/a", `{"session_id": "a", "node_id": "n1"}`),
+		kv("/session/a/694d7a2b/x", "lock"),
+	})
+	want := []SessionInfo{
+		{SessionID: "a", NodeID: "n1"},
+		{SessionID: "b", NodeID: "n2", NodeEndpoint: "ws://n2:7000"},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("etcdSessions = %+v, %v, want %+v", got, err, want)
+	}
+
+	if _, err := etcdSessions([]*mvccpb.KeyValue{kv("/session/a", "{")}); err == nil || !strings.Contains(err.Error(), "/session/a") {
+		t.Errorf("etcdSessions with a bad meta = %v, want a decoding error naming the key", err)
+	}
+}
@@ -0,0 +1,132 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	errMaxSessions       = errors.New("node has reached its session limit")
+	errMaxPeers          = errors.New("node has reached its peer limit")
+	errMaxIngressBitrate = errors.New("node has reached its ingress bitrate limit")
+	errMaxSessionPeers   = errors.New("session has reached its peer limit")
+	errMaxPublishTracks  = errors.New("peer has reached its published track limit")
+)
+
+// capacityErrorCode is the http and JSON-RPC error code for a limit, 503 when the node is full
+// and another node may accept the client, 429 when the session or peer itself is over its limit
+func capacityErrorCode(err error) (int, bool) {
+	switch {
+	case errors.Is(err, errMaxSessions), errors.Is(err, errMaxPeers), errors.Is(err, errMaxIngressBitrate), errors.Is(err, errDraining):
+		return http.StatusServiceUnavailable, true
+	case errors.Is(err, errMaxSessionPeers), errors.Is(err, errMaxPublishTracks):
+		return http.StatusTooManyRequests, true
+	}
+	return 0, false
+}
+
+// NodeLoad is the current load of a node, advertised to the cluster so full nodes
+// can send new sessions to nodes with capacity
+type NodeLoad struct {
+	NodeID         string `json:"node_id"`
+	NodeEndpoint   string `json:"node_endpoint"`
+	Sessions       int    `json:"sessions"`
+	Peers          int    `json:"peers"`
+	IngressBitrate uint64 `json:"ingress_bitrate"`
+	Full           bool   `json:"full"`
+	Draining       bool   `json:"draining"`
+}
+
+// loadOf sums the load of sessions, Full is set when they could not take another session
+func loadOf(sessions []*Session, limits LimitsConfig) NodeLoad {
+	load := NodeLoad{Sessions: len(sessions)}
+	for _, s := range sessions {
+		for _, peer := range s.Peers() {
+			load.Peers++
+			if peer.Publisher() == nil {
+				continue
+			}
+			for _, recv := range peer.Publisher().GetRouter().GetReceiver() {
+				for _, bitrate := range recv.GetBitrate() {
+					load.IngressBitrate += bitrate
+				}
+			}
+		}
+	}
+	load.Full = load.admitSession(limits) != nil
+	return load
+}
+
+// admitPeer checks the node can take another peer
+func (l NodeLoad) admitPeer(limits LimitsConfig) error {
+	if limits.MaxPeers > 0 && l.Peers >= limits.MaxPeers {
+		return fmt.Errorf("%w: %v", errMaxPeers, limits.MaxPeers)
+	}
+	if limits.MaxIngressBitrate > 0 && l.IngressBitrate >= limits.MaxIngressBitrate {
+		return fmt.Errorf("%w: %v bps", errMaxIngressBitrate, limits.MaxIngressBitrate)
+	}
+	return nil
+}
+
+// admitSession checks the node can take a new session and its first peer
+func (l NodeLoad) admitSession(limits LimitsConfig) error {
+	if limits.MaxSessions > 0 && l.Sessions >= limits.MaxSessions {
+		return fmt.Errorf("%w: %v", errMaxSessions, limits.MaxSessions)
+	}
+	return l.admitPeer(limits)
+}
+
+// admitSessionPeer checks session can take another peer
+func admitSessionPeer(session *Session, limits LimitsConfig) error {
+	if limits.MaxPeersPerSession > 0 && len(session.Peers()) >= limits.MaxPeersPerSession {
+		return fmt.Errorf("%w: %v", errMaxSessionPeers, limits.MaxPeersPerSession)
+	}
+	return nil
+}
+
+// admitPublishTracks checks the audio and video sections the peer offers to send fit the track limit
+func admitPublishTracks(offer webrtc.SessionDescription, limits LimitsConfig) error {
+	if limits.MaxPublishTracks <= 0 {
+		return nil
+	}
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return err
+	}
+
+	tracks := 0
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != "audio" && md.MediaName.Media != "video" {
+			continue
+		}
+		if _, ok := md.Attribute("recvonly"); ok {
+			continue
+		}
+		if _, ok := md.Attribute("inactive"); ok {
+			continue
+		}
+		tracks++
+	}
+
+	if tracks > limits.MaxPublishTracks {
+		return fmt.Errorf("%w: %v", errMaxPublishTracks, limits.MaxPublishTracks)
+	}
+	return nil
+}
+
+// admit checks the node, the session being joined and the join offer are within limits
+func (p *JSONSignal) admit(join Join) error {
+	limits := p.signal.conf().Limits
+	if err := loadOf(p.c.getLocalSessions(), limits).admitPeer(limits); err != nil {
+		return err
+	}
+	if session := localSession(p.c, join.SID); session != nil {
+		if err := admitSessionPeer(session, limits); err != nil {
+			return err
+		}
+	}
+	return admitPublishTracks(join.Offer, limits)
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// testOffer builds an offer with a media section for each "kind direction"
+func testOffer(sections ...string) webrtc.SessionDescription {
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n"
+	for i, section := range sections {
+		fields := strings.Fields(section)
+		if fields[0] == "application" {
+			sdp += fmt.Sprintf("m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\nc=IN IP4 0.0.0.0\r\na=mid:%v\r\n", i)
+			continue
+		}
+		sdp += fmt.Sprintf("m=%v 9 UDP/TLS/RTP/SAVPF 96\r\nc=IN IP4 0.0.0.0\r\na=mid:%v\r\na=%v\r\n", fields[0], i, fields[1])
+	}
+	return webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}
+}
+
+func TestAdmitPublishTracks(t *testing.T) {
+	tests := []struct {
+		name    string
+		offer   webrtc.SessionDescription
+		limit   int
+		wantErr error
+	}{
+		{"unlimited", testOffer("audio sendrecv", "video sendrecv", "video sendonly"), 0, nil},
+		{"within limit", testOffer("audio sendrecv", "video sendonly"), 2, nil},
+		{"over limit", testOffer("audio sendrecv", "video sendrecv", "video sendonly"), 2, errMaxPublishTracks},
+		{"receive only and inactive not counted", testOffer("audio sendrecv", "video recvonly", "video inactive"), 1, nil},
+		{"datachannel not counted", testOffer("audio sendrecv", "application"), 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := admitPublishTracks(tt.offer, LimitsConfig{MaxPublishTracks: tt.limit})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("admitPublishTracks() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLocalSessionLimit(t *testing.T) {
+	var conf RootConfig
+	conf.Signal.HTTPAddr = "127.0.0.1:7000"
+	conf.Signal.Limits.MaxSessions = 1
+	c, err := newCoordinatorLocal(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every new session is checked against the load of the sessions created before it
+	var wg sync.WaitGroup
+	var admitted int32
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, err := c.getOrCreateSession(context.Background(), fmt.Sprintf("session-%v", i))
+			switch {
+			case err == nil:
+				atomic.AddInt32(&admitted, 1)
+			case !errors.Is(err, errMaxSessions):
+				t.Errorf("getOrCreateSession() = %v, want %v", err, errMaxSessions)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if admitted != 1 || len(c.getLocalSessions()) != 1 {
+		t.Errorf("%v sessions admitted, %v created, want 1", admitted, len(c.getLocalSessions()))
+	}
+}
@@ -98,6 +98,18 @@ func NewClient(signal Signal, cfg *webrtc.Configuration, pubInterceptors []inter
 func (c *Client) Join(sid string) error {
 	c.signal.OnNegotiate(c.signalOnNegotiate)
 	c.signal.OnTrickle(c.signalOnTrickle)
+	c.signal.OnRestartICE(c.signalOnRestartICE)
+
+	// The server restarts the publisher when it sees it disconnect, the subscriber is restarted
+	// on request. It has to be asked for before ice fails, the server closes failed transports.
+	c.sub.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateDisconnected {
+			log.Info("client sub ice disconnected, requesting restart")
+			if err := c.RestartICE(roleSubscribe); err != nil {
+				log.Error(err, "client sub ice restart error")
+			}
+		}
+	})
 
 	c.sub.pc.OnTrack(func(track *webrtc.TrackRemote, recv *webrtc.RTPReceiver) {
 		log.Info("client sub got remote track", "streamID", track.Msid(), "trackID", track.ID())
@@ -137,45 +149,53 @@ func (c *Client) Join(sid string) error {
 
 // Publish takes a producer and publishes its data to the peer connection
 func (c *Client) Publish(p Producer) error {
-	videoSender, err := c.pub.pc.AddTrack(p.VideoTrack())
-	if err != nil {
-		return err
-	}
-	audioSender, err := c.pub.pc.AddTrack(p.AudioTrack())
-	if err != nil {
-		return err
+	// Producers of a single file may only have one of the tracks
+	for _, track := range []*webrtc.TrackLocalStaticSample{p.VideoTrack(), p.AudioTrack()} {
+		if track == nil {
+			continue
+		}
+		sender, err := c.pub.pc.AddTrack(track)
+		if err != nil {
+			return err
+		}
 
+		kind := track.Kind()
+		go func() {
+			rtcpBuf := make([]byte, 1500)
+			for {
+				if _, _, rtcpErr := sender.Read(rtcpBuf); rtcpErr != nil {
+					log.Error(rtcpErr, "sender rtcp error", "kind", kind)
+					return
+				}
+			}
+		}()
 	}
 	defer c.pubNegotiationNeeded()
 
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := videoSender.Read(rtcpBuf); rtcpErr != nil {
-				log.Error(err, "videoSender rtcp error")
-				return
-			}
-		}
-	}()
-
-	go func() {
-		rtcpBuf := make([]byte, 1500)
-		for {
-			if _, _, rtcpErr := audioSender.Read(rtcpBuf); rtcpErr != nil {
-				log.Error(err, "audioSender rtcp error")
-				return
-			}
-		}
-	}()
-
 	go p.Start()
 	return nil
 }
 
+// RestartICE restarts ice on the publisher or subscriber transport
+func (c *Client) RestartICE(role int) error {
+	if err := c.signal.RestartICE(role); err != nil {
+		return err
+	}
+
+	if role == rolePublish {
+		c.pubNegotiate(&webrtc.OfferOptions{ICERestart: true})
+	}
+	return nil
+}
+
 // Pub PC re-negotiation
 func (c *Client) pubNegotiationNeeded() {
 	log.Info("client pubOnNegotiationNeeded")
-	offer, err := c.pub.pc.CreateOffer(nil)
+	c.pubNegotiate(nil)
+}
+
+func (c *Client) pubNegotiate(options *webrtc.OfferOptions) {
+	offer, err := c.pub.pc.CreateOffer(options)
 	if err != nil {
 		log.Error(err, "pub could not create pub offer")
 		return
@@ -198,6 +218,15 @@ func (c *Client) pubNegotiationNeeded() {
 	log.Info("client negotiated")
 }
 
+// Close the publisher and subscriber peer connections
+func (c *Client) Close() error {
+	pubErr := c.pub.pc.Close()
+	if err := c.sub.pc.Close(); err != nil {
+		return err
+	}
+	return pubErr
+}
+
 // CreateDatachannel to publish
 func (c *Client) CreateDatachannel(label string) (*webrtc.DataChannel, error) {
 	return c.pub.pc.CreateDataChannel(label, nil)
@@ -228,6 +257,16 @@ func (c *Client) signalOnNegotiate(desc *webrtc.SessionDescription) {
 	c.signal.Answer(&answer)
 }
 
+// signalOnRestartICE is triggered from server when it wants the pub pc to restart ice
+func (c *Client) signalOnRestartICE(role int) {
+	if role != rolePublish {
+		return
+	}
+	log.Info("client restarting pub ice")
+	// negotiation calls the server, so it can't block the signal handler
+	go c.pubNegotiate(&webrtc.OfferOptions{ICERestart: true})
+}
+
 // signalOnNegotiate is triggered from server for the sub pc
 func (c *Client) signalOnTrickle(role int, candidate *webrtc.ICECandidateInit) {
 	var target *transport
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucsky/cuid"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+	"github.com/pion/webrtc/v3/pkg/media/ivfreader"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+var errUnknownMediaFile = errors.New("unsupported media file, use .ivf (vp8, vp9, av1), .h264 (annex-b) or .ogg (opus)")
+
+// FileProducerConfig the files a FileProducer publishes, either may be empty
+type FileProducerConfig struct {
+	// Video is an .ivf (VP8, VP9, AV1) or .h264 / .264 (H.264 annex-b) file
+	Video string
+	// Audio is an .ogg / .opus file with an opus stream
+	Audio string
+	// Loop the files from the start once they end
+	Loop bool
+	// FrameRate of the H.264 file, which has no timestamps, defaults to 30
+	FrameRate int
+}
+
+// FileProducer publishes pre-encoded media files, paced in real time, without gstreamer.
+// The files are sent as they are, so they must use codecs the subscribers can decode.
+type FileProducer struct {
+	conf       FileProducerConfig
+	audioTrack *webrtc.TrackLocalStaticSample
+	videoTrack *webrtc.TrackLocalStaticSample
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// IsMediaFile reports whether path is a file a FileProducer can publish, and if it is video
+func IsMediaFile(path string) (ok bool, video bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ivf", ".h264", ".264":
+		return true, true
+	case ".ogg", ".opus":
+		return true, false
+	}
+	return false, false
+}
+
+// NewFileProducer checks the files in conf and creates tracks for them
+func NewFileProducer(conf FileProducerConfig) (*FileProducer, error) {
+	if conf.Video == "" && conf.Audio == "" {
+		return nil, fmt.Errorf("no media files to produce")
+	}
+	if conf.FrameRate <= 0 {
+		conf.FrameRate = 30
+	}
+
+	p := &FileProducer{conf: conf, done: make(chan struct{})}
+	stream := fmt.Sprintf("file-%v", cuid.New())
+
+	if conf.Video != "" {
+		mimeType, err := videoMimeType(conf.Video)
+		if err != nil {
+			return nil, err
+		}
+		if p.videoTrack, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000}, cuid.New(), stream); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Audio != "" {
+		if ok, video := IsMediaFile(conf.Audio); !ok || video {
+			return nil, fmt.Errorf("%v: %w", conf.Audio, errUnknownMediaFile)
+		}
+		var err error
+		if p.audioTrack, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeTypeOpus, ClockRate: 48000, Channels: 2}, cuid.New(), stream); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// videoMimeType of an ivf file from its fourcc, or h264 for annex-b files
+func videoMimeType(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".h264", ".264":
+		return mimeTypeH264, nil
+	case ".ivf":
+	default:
+		return "", fmt.Errorf("%v: %w", path, errUnknownMediaFile)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, header, err := ivfreader.NewWith(f)
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", path, err)
+	}
+
+	switch header.FourCC {
+	case "VP80":
+		return mimeTypeVP8, nil
+	case "VP90":
+		return mimeTypeVP9, nil
+	case "AV01":
+		return mimeTypeAV1, nil
+	}
+	return "", fmt.Errorf("%v: unsupported ivf codec %q", path, header.FourCC)
+}
+
+//AudioTrack returns the audio track, nil without an audio file
+func (p *FileProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return p.audioTrack
+}
+
+//VideoTrack returns the video track, nil without a video file
+func (p *FileProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return p.videoTrack
+}
+
+//Start sending the files until they end, or Stop when looping
+func (p *FileProducer) Start() {
+	var wg sync.WaitGroup
+	if p.videoTrack != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if strings.EqualFold(p.videoTrack.Codec().MimeType, mimeTypeH264) {
+				p.play(p.conf.Video, p.videoTrack, p.readH264)
+			} else {
+				p.play(p.conf.Video, p.videoTrack, p.readIVF)
+			}
+		}()
+	}
+	if p.audioTrack != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.play(p.conf.Audio, p.audioTrack, p.readOgg)
+		}()
+	}
+	wg.Wait()
+}
+
+//Stop sending
+func (p *FileProducer) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// sampleTrack is the track a sampleWriter writes to
+type sampleTrack interface {
+	WriteSample(s media.Sample) error
+}
+
+// sampleWriter paces samples in real time onto a track
+type sampleWriter struct {
+	done  <-chan struct{}
+	track sampleTrack
+	next  time.Time
+}
+
+// write waits until the sample is due and writes it, false once stopped
+func (w *sampleWriter) write(data []byte, duration time.Duration) (bool, error) {
+	// A sample that is already due would otherwise race the stop in the select below
+	select {
+	case <-w.done:
+		return false, nil
+	default:
+	}
+	select {
+	case <-w.done:
+		return false, nil
+	case <-time.After(time.Until(w.next)):
+	}
+	w.next = w.next.Add(duration)
+	return true, w.track.WriteSample(media.Sample{Data: data, Duration: duration})
+}
+
+// play reads path with read, from the start again each time it ends when looping
+func (p *FileProducer) play(path string, track *webrtc.TrackLocalStaticSample, read func(f *os.File, w *sampleWriter) error) {
+	w := &sampleWriter{done: p.done, track: track, next: time.Now()}
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Error(err, "error opening media file", "file", path)
+			return
+		}
+		err = read(f, w)
+		f.Close()
+
+		if err != nil && err != io.EOF {
+			log.Error(err, "error reading media file", "file", path)
+			return
+		}
+		if err == nil || !p.conf.Loop {
+			return
+		}
+		log.V(1).Info("looping media file", "file", path)
+	}
+}
+
+// readIVF sends each ivf frame for the time between its timestamp and the next one
+func (p *FileProducer) readIVF(f *os.File, w *sampleWriter) error {
+	reader, header, err := ivfreader.NewWith(f)
+	if err != nil {
+		return err
+	}
+	timebase := time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+
+	frame, frameHeader, err := reader.ParseNextFrame()
+	for err == nil {
+		next, nextHeader, nextErr := reader.ParseNextFrame()
+
+		duration := timebase
+		if nextErr == nil && nextHeader.Timestamp > frameHeader.Timestamp {
+			duration = time.Duration(nextHeader.Timestamp-frameHeader.Timestamp) * timebase
+		}
+		if ok, writeErr := w.write(frame, duration); !ok || writeErr != nil {
+			return writeErr
+		}
+
+		frame, frameHeader, err = next, nextHeader, nextErr
+	}
+	return err
+}
+
+// readH264 sends each coded slice, with the parameter sets before it, as a frame at the
+// configured frame rate. Encoders writing several slices per frame aren't paced correctly.
+func (p *FileProducer) readH264(f *os.File, w *sampleWriter) error {
+	reader, err := h264reader.NewReader(f)
+	if err != nil {
+		return err
+	}
+	duration := time.Second / time.Duration(p.conf.FrameRate)
+
+	var frame []byte
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			return err
+		}
+
+		frame = append(frame, 0, 0, 0, 1)
+		frame = append(frame, nal.Data...)
+		if nal.UnitType != h264reader.NalUnitTypeCodedSliceIdr && nal.UnitType != h264reader.NalUnitTypeCodedSliceNonIdr {
+			continue
+		}
+
+		if ok, writeErr := w.write(frame, duration); !ok || writeErr != nil {
+			return writeErr
+		}
+		frame = nil
+	}
+}
+
+// readOgg sends each ogg page for the samples its granule position advances by.
+// Pages should hold one opus packet, e.g. ffmpeg -page_duration 20000.
+func (p *FileProducer) readOgg(f *os.File, w *sampleWriter) error {
+	reader, _, err := oggreader.NewWith(f)
+	if err != nil {
+		return err
+	}
+
+	var lastGranule uint64
+	for {
+		page, header, err := reader.ParseNextPage()
+		if err != nil {
+			return err
+		}
+		// The comment header page has no audio
+		if header.GranulePosition == 0 {
+			continue
+		}
+		samples := header.GranulePosition - lastGranule
+		lastGranule = header.GranulePosition
+
+		duration := time.Duration(samples) * time.Second / 48000
+		if ok, writeErr := w.write(page, duration); !ok || writeErr != nil {
+			return writeErr
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+type recordTrack struct {
+	samples []media.Sample
+}
+
+func (r *recordTrack) WriteSample(s media.Sample) error {
+	r.samples = append(r.samples, s)
+	return nil
+}
+
+// testFile writes data to a file in a temporary directory and opens it
+func testFile(t *testing.T, name string, data []byte) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// ivfFile builds a VP8 ivf file with a millisecond timebase
+func ivfFile(timestamps []uint64, frames [][]byte) []byte {
+	var b bytes.Buffer
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[6:], 32)
+	copy(header[8:], "VP80")
+	binary.LittleEndian.PutUint32(header[16:], 1000)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	binary.LittleEndian.PutUint32(header[24:], uint32(len(frames)))
+	b.Write(header)
+	for i, frame := range frames {
+		frameHeader := make([]byte, 12)
+		binary.LittleEndian.PutUint32(frameHeader[0:], uint32(len(frame)))
+		binary.LittleEndian.PutUint64(frameHeader[4:], timestamps[i])
+		b.Write(frameHeader)
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// testWriter writes without pacing, every sample is already due
+func testWriter(track sampleTrack, done chan struct{}) *sampleWriter {
+	return &sampleWriter{done: done, track: track, next: time.Now().Add(-time.Hour)}
+}
+
+func TestReadIVF(t *testing.T) {
+	frames := [][]byte{{1}, {2, 2}, {3, 3, 3}, {4}}
+	tests := []struct {
+		name       string
+		timestamps []uint64
+		want       []time.Duration
+	}{
+		{"frame timestamps", []uint64{0, 33, 66, 100}, []time.Duration{33 * time.Millisecond, 33 * time.Millisecond, 34 * time.Millisecond, time.Millisecond}},
+		{"repeated timestamp takes the timebase", []uint64{0, 0, 40, 80}, []time.Duration{time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond, time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := &recordTrack{}
+			f := testFile(t, "video.ivf", ivfFile(tt.timestamps, frames))
+			if err := (&FileProducer{}).readIVF(f, testWriter(track, make(chan struct{}))); err != io.EOF {
+				t.Fatalf("readIVF() = %v, want %v", err, io.EOF)
+			}
+
+			var got []time.Duration
+			for i, s := range track.samples {
+				if !bytes.Equal(s.Data, frames[i]) {
+					t.Errorf("frame %v = %v, want %v", i, s.Data, frames[i])
+				}
+				got = append(got, s.Duration)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("durations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadH264(t *testing.T) {
+	start := []byte{0, 0, 0, 1}
+	nal := func(header byte) []byte { return []byte{header, 0xaa, 0xbb} }
+	sps, pps, idr, slice := nal(0x67), nal(0x68), nal(0x65), nal(0x41)
+
+	var file []byte
+	for _, n := range [][]byte{sps, pps, idr, slice, slice} {
+		file = append(append(file, start...), n...)
+	}
+	frame := func(nals ...[]byte) []byte {
+		var b []byte
+		for _, n := range nals {
+			b = append(append(b, start...), n...)
+		}
+		return b
+	}
+	want := [][]byte{frame(sps, pps, idr), frame(slice), frame(slice)}
+
+	track := &recordTrack{}
+	p := &FileProducer{conf: FileProducerConfig{FrameRate: 25}}
+	if err := p.readH264(testFile(t, "video.h264", file), testWriter(track, make(chan struct{}))); err != io.EOF {
+		t.Fatalf("readH264() = %v, want %v", err, io.EOF)
+	}
+	if len(track.samples) != len(want) {
+		t.Fatalf("got %v frames, want %v", len(track.samples), len(want))
+	}
+	for i, s := range track.samples {
+		if !bytes.Equal(s.Data, want[i]) {
+			t.Errorf("frame %v = %x, want %x", i, s.Data, want[i])
+		}
+		if s.Duration != 40*time.Millisecond {
+			t.Errorf("frame %v duration = %v, want %v", i, s.Duration, 40*time.Millisecond)
+		}
+	}
+}
+
+// oggPage builds an ogg page holding payload as a single segment
+func oggPage(headerType byte, granule uint64, index uint32, payload []byte) []byte {
+	page := make([]byte, 27, 28+len(payload))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], 1)
+	binary.LittleEndian.PutUint32(page[18:], index)
+	page[26] = 1
+	page = append(page, byte(len(payload)))
+	page = append(page, payload...)
+
+	var crc uint32
+	for _, b := range page {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+func TestReadOgg(t *testing.T) {
+	payloads := [][]byte{{1}, {2, 2}, {3, 3, 3}}
+	granules := []uint64{960, 1920, 2400}
+
+	idHeader := make([]byte, 19)
+	copy(idHeader, "OpusHead")
+	idHeader[8], idHeader[9] = 1, 2
+	binary.LittleEndian.PutUint32(idHeader[12:], 48000)
+
+	file := oggPage(2, 0, 0, idHeader)
+	file = append(file, oggPage(0, 0, 1, []byte("OpusTags"))...)
+	for i, payload := range payloads {
+		file = append(file, oggPage(0, granules[i], uint32(i+2), payload)...)
+	}
+
+	track := &recordTrack{}
+	if err := (&FileProducer{}).readOgg(testFile(t, "audio.ogg", file), testWriter(track, make(chan struct{}))); err != io.EOF {
+		t.Fatalf("readOgg() = %v, want %v", err, io.EOF)
+	}
+
+	want := []time.Duration{20 * time.Millisecond, 20 * time.Millisecond, 10 * time.Millisecond}
+	if len(track.samples) != len(payloads) {
+		t.Fatalf("got %v pages, want %v", len(track.samples), len(payloads))
+	}
+	for i, s := range track.samples {
+		if !bytes.Equal(s.Data, payloads[i]) {
+			t.Errorf("page %v = %v, want %v", i, s.Data, payloads[i])
+		}
+		if s.Duration != want[i] {
+			t.Errorf("page %v duration = %v, want %v", i, s.Duration, want[i])
+		}
+	}
+}
+
+func TestReadStopped(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+
+	track := &recordTrack{}
+	f := testFile(t, "video.ivf", ivfFile([]uint64{0, 33}, [][]byte{{1}, {2}}))
+	if err := (&FileProducer{}).readIVF(f, testWriter(track, done)); err != nil {
+		t.Errorf("readIVF() = %v, want nil once stopped", err)
+	}
+	if len(track.samples) != 0 {
+		t.Errorf("wrote %v samples after stop", len(track.samples))
+	}
+}
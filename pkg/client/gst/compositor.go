@@ -1,3 +1,6 @@
+//go:build gst
+// +build gst
+
 package gst
 
 /*
@@ -0,0 +1,42 @@
+//go:build !gst
+// +build !gst
+
+package client
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// GSTProducer is not available without the gst build tag
+type GSTProducer struct{}
+
+// NewGSTProducer returns ErrGSTNotCompiled, build with -tags gst to produce from gstreamer
+func NewGSTProducer(c *Client, kind string, path string) (*GSTProducer, error) {
+	return nil, ErrGSTNotCompiled
+}
+
+//AudioTrack returns nil
+func (t *GSTProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return nil
+}
+
+//VideoTrack returns nil
+func (t *GSTProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return nil
+}
+
+//SeekP does nothing
+func (t *GSTProducer) SeekP(ts int) {
+}
+
+//Pause does nothing
+func (t *GSTProducer) Pause(pause bool) {
+}
+
+//Stop does nothing
+func (t *GSTProducer) Stop() {
+}
+
+//Start does nothing
+func (t *GSTProducer) Start() {
+}
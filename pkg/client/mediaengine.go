@@ -7,6 +7,9 @@ import (
 
 const (
 	mimeTypeH264 = "video/h264"
+	mimeTypeVP8  = "video/vp8"
+	mimeTypeVP9  = "video/vp9"
+	mimeTypeAV1  = "video/av1"
 	mimeTypeOpus = "audio/opus"
 )
 
@@ -25,6 +28,18 @@ func getProducerMediaEngine() (*webrtc.MediaEngine, error) {
 			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f", RTCPFeedback: videoRTCPFeedback},
 			PayloadType:        102,
 		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP8, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        96,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeVP9, ClockRate: 90000, SDPFmtpLine: "profile-id=0", RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        98,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeAV1, ClockRate: 90000, RTCPFeedback: videoRTCPFeedback},
+			PayloadType:        45,
+		},
 	} {
 		if err := me.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
 			return nil, err
@@ -3,7 +3,10 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"sync"
 
 	cluster "github.com/pion/ion-cluster/pkg"
 
@@ -13,13 +16,27 @@ import (
 	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
 )
 
+// maxJoinRedirects is how many times a join follows the session to another node
+const maxJoinRedirects = 3
+
 var (
-	errNotConnected = fmt.Errorf("error no connection established")
+	errNotConnected     = fmt.Errorf("error no connection established")
+	errTooManyRedirects = errors.New("too many join redirects")
+	errNotResumable     = errors.New("no resume token, the server has resume disabled or the session wasn't joined")
 )
 
+// joinRedirect is the session meta a node replies to join with, as a 302 error,
+// when the session is hosted on another node
+type joinRedirect struct {
+	SessionID    string `json:"session_id"`
+	NodeID       string `json:"node_id"`
+	NodeEndpoint string `json:"node_endpoint"`
+}
+
 // Signal is the RPC Interface for ion-cluster
 type Signal interface {
 	Open(url string) (closed <-chan struct{}, err error)
+	Resume(url string) (closed <-chan struct{}, err error)
 	Close() error
 	Ping() error
 
@@ -27,18 +44,36 @@ type Signal interface {
 	Offer(offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error)
 	Answer(answer *webrtc.SessionDescription) error
 	Trickle(target int, trickle *webrtc.ICECandidateInit) error
+	Leave() error
+	RestartICE(target int) error
 
 	OnNegotiate(func(offer *webrtc.SessionDescription))
 	OnTrickle(func(target int, trickle *webrtc.ICECandidateInit))
+	OnDisconnect(func(reason cluster.DisconnectReason))
+	OnRestartICE(func(target int))
 }
 
 // JSONRPCSignalClient is a websocket jsonrpc2 client for ion-cluster
 type JSONRPCSignalClient struct {
 	context context.Context
-	jc      *jsonrpc2.Conn
 
-	onNegotiate func(jsep *webrtc.SessionDescription)
-	onTrickle   func(target int, trickle *webrtc.ICECandidateInit)
+	mu     sync.Mutex
+	jc     *jsonrpc2.Conn
+	url    string
+	closed chan struct{}
+	// closeOnce closes closed, shared by every connection dialed for it
+	closeOnce *sync.Once
+	// trickles sent before joining, sent again to the node a join is redirected to
+	trickles []cluster.Trickle
+	joined   bool
+	sid      string
+	// resume reattaches a new connection to the joined peer, set from the resumable notification
+	resume *cluster.Resume
+
+	onNegotiate  func(jsep *webrtc.SessionDescription)
+	onTrickle    func(target int, trickle *webrtc.ICECandidateInit)
+	onDisconnect func(reason cluster.DisconnectReason)
+	onRestartICE func(target int)
 }
 
 // NewJSONRPCSignalClient constructor
@@ -46,48 +81,184 @@ func NewJSONRPCSignalClient(ctx context.Context) Signal {
 	return &JSONRPCSignalClient{context: ctx}
 }
 
-// Open connects to the given url
+// Open connects to the given url, closed is notified once the connection is closed,
+// it stays open while a join is redirected to another node
 func (c *JSONRPCSignalClient) Open(url string) (<-chan struct{}, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	closed := make(chan struct{})
+	c.mu.Lock()
+	c.closed = closed
+	c.closeOnce = &sync.Once{}
+	c.mu.Unlock()
+	if err := c.dial(url); err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// Resume connects to url after the connection was lost and reattaches it to the peer that
+// joined, which the server keeps for the grace period of its resumable notification. The url
+// is the session url Open was called with, its token may be a fresh one for the same session.
+// A failed resume closes the connection, the peer is gone and the session has to be joined again.
+func (c *JSONRPCSignalClient) Resume(url string) (<-chan struct{}, error) {
+	c.mu.Lock()
+	resume := c.resume
+	c.mu.Unlock()
+	if resume == nil {
+		return nil, errNotResumable
+	}
+
+	closed, err := c.Open(url)
 	if err != nil {
 		return nil, err
 	}
 
-	c.jc = jsonrpc2.NewConn(c.context, websocketjsonrpc2.NewObjectStream(conn), c)
-	return c.jc.DisconnectNotify(), nil
+	log.Info("signal client sending resume", "sessionID", resume.SID)
+	if err := c.conn().Call(c.context, "resume", resume, nil); err != nil {
+		c.mu.Lock()
+		c.resume = nil
+		c.mu.Unlock()
+		_ = c.Close()
+		return nil, err
+	}
+	return closed, nil
+}
+
+// dial connects to url and makes it the current connection
+func (c *JSONRPCSignalClient) dial(url string) error {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+
+	jc := jsonrpc2.NewConn(c.context, websocketjsonrpc2.NewObjectStream(conn), c)
+	c.mu.Lock()
+	c.jc = jc
+	c.url = url
+	closed, closeOnce := c.closed, c.closeOnce
+	c.mu.Unlock()
+
+	go func() {
+		<-jc.DisconnectNotify()
+		c.mu.Lock()
+		replaced := c.jc != jc
+		c.mu.Unlock()
+		if !replaced {
+			closeOnce.Do(func() { close(closed) })
+		}
+	}()
+	return nil
+}
+
+// conn is the current connection, nil before Open
+func (c *JSONRPCSignalClient) conn() *jsonrpc2.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.jc
 }
 
 // Close disconnects the websocket
 func (c *JSONRPCSignalClient) Close() error {
-	return c.jc.Close()
+	return c.conn().Close()
 }
 
-// Join a session id with an sdp offer (returns an sdp answer or error)
+// Join a session id with an sdp offer (returns an sdp answer or error). When the session
+// is hosted on another node the join is retried there, with the same token.
 func (c *JSONRPCSignalClient) Join(sid string, offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	if c.jc == nil {
+	if c.conn() == nil {
 		return nil, errNotConnected
 	}
+	c.mu.Lock()
+	c.sid = sid
+	c.resume = nil
+	c.mu.Unlock()
+
+	for redirects := 0; ; redirects++ {
+		log.Info("signal client sending join", "sessionID", sid)
+		var answer *webrtc.SessionDescription
+
+		err := c.conn().Call(c.context, "join", &cluster.Join{SID: sid, Offer: *offer}, &answer)
+		if err == nil {
+			c.mu.Lock()
+			trickles := c.trickles
+			c.trickles = nil
+			c.joined = true
+			c.mu.Unlock()
+
+			if redirects > 0 {
+				c.resendTrickles(trickles)
+			}
+			return answer, nil
+		}
 
-	log.Info("signal client sending join", "sessionID", sid)
-	var answer *webrtc.SessionDescription
+		redirect, ok := isJoinRedirect(err)
+		if !ok {
+			return nil, err
+		}
+		if redirects >= maxJoinRedirects {
+			return nil, fmt.Errorf("%w: session %v last on %v", errTooManyRedirects, sid, redirect.NodeEndpoint)
+		}
+		if err := c.redirect(redirect); err != nil {
+			return nil, err
+		}
+	}
+}
 
-	err := c.jc.Call(c.context, "join", &cluster.Join{SID: sid, Offer: *offer}, &answer)
+// isJoinRedirect reports whether err is a node redirecting a join
+func isJoinRedirect(err error) (*joinRedirect, bool) {
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != 302 {
+		return nil, false
+	}
+	var redirect joinRedirect
+	if err := json.Unmarshal([]byte(rpcErr.Message), &redirect); err != nil || redirect.NodeEndpoint == "" {
+		return nil, false
+	}
+	return &redirect, true
+}
+
+// redirect reconnects to the node in redirect, keeping the session path and token
+func (c *JSONRPCSignalClient) redirect(redirect *joinRedirect) error {
+	c.mu.Lock()
+	current := c.url
+	c.mu.Unlock()
+
+	u, err := url.Parse(current)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	endpoint, err := url.Parse(redirect.NodeEndpoint)
+	if err != nil {
+		return fmt.Errorf("parsing redirect endpoint %q: %w", redirect.NodeEndpoint, err)
 	}
+	u.Scheme, u.Host = endpoint.Scheme, endpoint.Host
 
-	return answer, nil
+	log.Info("signal client join redirected", "sessionID", redirect.SessionID, "nodeID", redirect.NodeID, "endpoint", endpoint.Host)
+	old := c.conn()
+	if err := c.dial(u.String()); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// resendTrickles sends the candidates gathered before a redirect to the node joined
+func (c *JSONRPCSignalClient) resendTrickles(trickles []cluster.Trickle) {
+	for i := range trickles {
+		if err := c.conn().Notify(c.context, "trickle", &trickles[i]); err != nil {
+			log.Error(err, "signal client error resending trickle ice")
+			return
+		}
+	}
 }
 
 // Offer a new sdp to the server (returns an sdp answer)
 func (c *JSONRPCSignalClient) Offer(offer *webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
-	if c.jc == nil {
+	if c.conn() == nil {
 		return nil, errNotConnected
 	}
 
 	log.Info("signal client sending offer")
 	var answer *webrtc.SessionDescription
-	err := c.jc.Call(c.context, "offer", &cluster.Negotiation{Desc: *offer}, &answer)
+	err := c.conn().Call(c.context, "offer", &cluster.Negotiation{Desc: *offer}, &answer)
 	if err != nil {
 		return nil, err
 	}
@@ -97,22 +268,52 @@ func (c *JSONRPCSignalClient) Offer(offer *webrtc.SessionDescription) (*webrtc.S
 
 // Answer an sdp offer that originated from the server
 func (c *JSONRPCSignalClient) Answer(answer *webrtc.SessionDescription) error {
-	if c.jc == nil {
+	if c.conn() == nil {
 		return errNotConnected
 	}
 
 	log.Info("signal client sending answer")
-	return c.jc.Notify(c.context, "answer", &cluster.Negotiation{Desc: *answer})
+	return c.conn().Notify(c.context, "answer", &cluster.Negotiation{Desc: *answer})
 }
 
 // Trickle send ice candiates to the server
 func (c *JSONRPCSignalClient) Trickle(target int, trickle *webrtc.ICECandidateInit) error {
-	if c.jc == nil {
+	if c.conn() == nil {
 		return errNotConnected
 	}
 
 	log.Info("signal client sending trickle ice")
-	return c.jc.Notify(c.context, "trickle", &cluster.Trickle{Target: target, Candidate: *trickle})
+	t := cluster.Trickle{Target: target, Candidate: *trickle}
+	c.mu.Lock()
+	if !c.joined {
+		c.trickles = append(c.trickles, t)
+	}
+	c.mu.Unlock()
+	return c.conn().Notify(c.context, "trickle", &t)
+}
+
+// Leave the session, the server closes the connection afterwards
+func (c *JSONRPCSignalClient) Leave() error {
+	if c.conn() == nil {
+		return errNotConnected
+	}
+
+	log.Info("signal client sending leave")
+	c.mu.Lock()
+	c.resume = nil
+	c.mu.Unlock()
+	return c.conn().Call(c.context, "leave", nil, nil)
+}
+
+// RestartICE asks the server to restart ice on a transport, for the subscriber the server
+// sends an offer with ice restart, for the publisher the client must send one
+func (c *JSONRPCSignalClient) RestartICE(target int) error {
+	if c.conn() == nil {
+		return errNotConnected
+	}
+
+	log.Info("signal client sending restart ice", "target", target)
+	return c.conn().Call(c.context, "restart_ice", &cluster.RestartICE{Target: target}, nil)
 }
 
 // Handle handles incoming jsonrpc2 messages
@@ -144,16 +345,55 @@ func (c *JSONRPCSignalClient) Handle(ctx context.Context, conn *jsonrpc2.Conn, r
 		if c.onTrickle != nil {
 			c.onTrickle(trickle.Target, &trickle.Candidate)
 		}
+
+	case "disconnect":
+		var disconnect cluster.Disconnect
+		err := json.Unmarshal(*req.Params, &disconnect)
+		if err != nil {
+			log.Error(err, "error parsing disconnect from server")
+			break
+		}
+		log.Info("signal client got disconnect", "reason", disconnect.Reason)
+
+		if c.onDisconnect != nil {
+			c.onDisconnect(disconnect.Reason)
+		}
+
+	case "resumable":
+		var resumable cluster.Resumable
+		err := json.Unmarshal(*req.Params, &resumable)
+		if err != nil {
+			log.Error(err, "error parsing resumable from server")
+			break
+		}
+		log.Info("signal client got resumable", "gracePeriodMS", resumable.GracePeriodMS)
+
+		c.mu.Lock()
+		c.resume = &cluster.Resume{SID: c.sid, Token: resumable.Token}
+		c.mu.Unlock()
+
+	case "restart_ice":
+		var restart cluster.RestartICE
+		err := json.Unmarshal(*req.Params, &restart)
+		if err != nil {
+			log.Error(err, "error parsing restart ice from server")
+			break
+		}
+		log.Info("signal client got restart ice", "target", restart.Target)
+
+		if c.onRestartICE != nil {
+			c.onRestartICE(restart.Target)
+		}
 	}
 }
 
 // Ping sends a ping message
 func (c *JSONRPCSignalClient) Ping() error {
-	if c.jc == nil {
+	if c.conn() == nil {
 		return errNotConnected
 	}
 
-	return c.jc.Call(c.context, "ping", nil, nil)
+	return c.conn().Call(c.context, "ping", nil, nil)
 }
 
 //OnNegotiate hook a negotiation handler
@@ -165,3 +405,13 @@ func (c *JSONRPCSignalClient) OnNegotiate(cb func(offer *webrtc.SessionDescripti
 func (c *JSONRPCSignalClient) OnTrickle(cb func(target int, trickle *webrtc.ICECandidateInit)) {
 	c.onTrickle = cb
 }
+
+//OnDisconnect hook a handler for server initiated disconnects
+func (c *JSONRPCSignalClient) OnDisconnect(cb func(reason cluster.DisconnectReason)) {
+	c.onDisconnect = cb
+}
+
+//OnRestartICE hook a handler for server initiated ice restarts
+func (c *JSONRPCSignalClient) OnRestartICE(cb func(target int)) {
+	c.onRestartICE = cb
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cluster "github.com/pion/ion-cluster/pkg"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
+)
+
+const testTimeout = 5 * time.Second
+
+// handlerFunc is a jsonrpc2.Handler replying itself
+type handlerFunc func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request)
+
+func (h handlerFunc) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	h(ctx, conn, req)
+}
+
+// testServer is a signal websocket server answering requests with handle
+type testServer struct {
+	*httptest.Server
+	url string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// requested returns the request uri of every websocket opened
+func (s *testServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func newTestServer(t *testing.T, handle handlerFunc) *testServer {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		s.mu.Unlock()
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		jc := jsonrpc2.NewConn(r.Context(), websocketjsonrpc2.NewObjectStream(ws), handle)
+		<-jc.DisconnectNotify()
+	}))
+	t.Cleanup(s.Close)
+	s.url = "ws" + strings.TrimPrefix(s.URL, "http") + "/session/test?access_token=token"
+	return s
+}
+
+func waitClosed(t *testing.T, closed <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-closed:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the connection to close")
+	}
+}
+
+func TestSignalResume(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		dropped int
+		resumed []cluster.Resume
+	)
+	srv := newTestServer(t, func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch req.Method {
+		case "join":
+			_ = conn.Reply(ctx, req.ID, webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer})
+			_ = conn.Notify(ctx, "resumable", cluster.Resumable{Token: "resume-token", GracePeriodMS: 1000})
+		case "ping":
+			// Drop the websocket, as a network failure would
+			dropped++
+			conn.Close()
+		case "resume":
+			var resume cluster.Resume
+			_ = json.Unmarshal(*req.Params, &resume)
+			resumed = append(resumed, resume)
+			if len(resumed) > 1 {
+				_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{Code: 500, Message: "unknown or expired resume token"})
+				return
+			}
+			_ = conn.Reply(ctx, req.ID, true)
+		}
+	})
+
+	c := NewJSONRPCSignalClient(context.Background()).(*JSONRPCSignalClient)
+	closed, err := c.Open(srv.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Resume(srv.url); err != errNotResumable {
+		t.Errorf("resume before join = %v, want %v", err, errNotResumable)
+	}
+	if _, err := c.Join("test", &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(testTimeout)
+	for {
+		c.mu.Lock()
+		resumable := c.resume != nil
+		c.mu.Unlock()
+		if resumable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the resumable notification")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_ = c.Ping()
+	waitClosed(t, closed)
+
+	closed, err = c.Resume(srv.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if want := (cluster.Resume{SID: "test", Token: "resume-token"}); len(resumed) != 1 || resumed[0] != want {
+		t.Errorf("server got resume %+v, want %+v", resumed, want)
+	}
+	mu.Unlock()
+	select {
+	case <-closed:
+		t.Fatal("resumed connection closed")
+	default:
+	}
+
+	// A failed resume closes the connection and forgets the token
+	_ = c.Ping()
+	waitClosed(t, closed)
+	if _, err := c.Resume(srv.url); err == nil || !strings.Contains(err.Error(), "unknown or expired") {
+		t.Errorf("resume with a spent token = %v, want unknown or expired", err)
+	}
+	if _, err := c.Resume(srv.url); err != errNotResumable {
+		t.Errorf("resume after a failed resume = %v, want %v", err, errNotResumable)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if dropped != 2 {
+		t.Errorf("dropped %v connections, want 2", dropped)
+	}
+}
+
+func TestIsJoinRedirect(t *testing.T) {
+	redirect := func(meta string) error { return &jsonrpc2.Error{Code: 302, Message: meta} }
+	for _, tc := range []struct {
+		name string
+		err  error
+		want *joinRedirect
+	}{
+		{"nil", nil, nil},
+		{"not an rpc error", errors.New("failed"), nil},
+		{"another code", &jsonrpc2.Error{Code: 500, Message: `{"node_endpoint": "ws://b:7000"}`}, nil},
+		{"not json", redirect("moved"), nil},
+		{"no endpoint", redirect(`{"session_id": "test", "node_id": "b"}`), nil},
+		{"redirect", redirect(`{"session_id": "test", "node_id": "b", "node_endpoint": "ws://b:7000"}`),
+			&joinRedirect{SessionID: "test", NodeID: "b", NodeEndpoint: "ws://b:7000"}},
+		{"wrapped", fmt.Errorf("join: %w", redirect(`{"node_endpoint": "ws://b:7000"}`)),
+			&joinRedirect{NodeEndpoint: "ws://b:7000"}},
+	} {
+		got, ok := isJoinRedirect(tc.err)
+		if ok != (tc.want != nil) || (ok && *got != *tc.want) {
+			t.Errorf("%v: isJoinRedirect = %+v, %v, want %+v", tc.name, got, ok, tc.want)
+		}
+	}
+}
+
+// redirectTo replies to join with a redirect to the node at endpoint
+func redirectTo(endpoint *string) handlerFunc {
+	return func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		if req.Method != "join" {
+			return
+		}
+		meta, _ := json.Marshal(joinRedirect{SessionID: "test", NodeID: "other", NodeEndpoint: *endpoint})
+		_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{Code: 302, Message: string(meta)})
+	}
+}
+
+func TestSignalJoinRedirect(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		trickles []cluster.Trickle
+	)
+	b := newTestServer(t, func(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+		switch req.Method {
+		case "join":
+			_ = conn.Reply(ctx, req.ID, webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "b"})
+		case "trickle":
+			var trickle cluster.Trickle
+			_ = json.Unmarshal(*req.Params, &trickle)
+			mu.Lock()
+			trickles = append(trickles, trickle)
+			mu.Unlock()
+		}
+	})
+	endpoint := "ws" + strings.TrimPrefix(b.URL, "http")
+	a := newTestServer(t, redirectTo(&endpoint))
+
+	c := NewJSONRPCSignalClient(context.Background()).(*JSONRPCSignalClient)
+	closed, err := c.Open(a.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate := "candidate:1 1 udp 1 10.0.0.1 5000 typ host"
+	if err := c.Trickle(0, &webrtc.ICECandidateInit{Candidate: candidate}); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := c.Join("test", &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.SDP != "b" {
+		t.Errorf("answer from %q, want b", answer.SDP)
+	}
+	if got := b.requested(); len(got) != 1 || got[0] != "/session/test?access_token=token" {
+		t.Errorf("redirected to %v, want the session path and token", got)
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		mu.Lock()
+		n := len(trickles)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the trickle to be resent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	if len(trickles) != 1 || trickles[0].Candidate.Candidate != candidate {
+		t.Errorf("redirected node got trickles %+v, want the one sent before join", trickles)
+	}
+	mu.Unlock()
+
+	// Closing the first node's websocket doesn't close the client
+	select {
+	case <-closed:
+		t.Fatal("closed after the redirect")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_ = c.Close()
+	waitClosed(t, closed)
+}
+
+func TestSignalJoinRedirectLoop(t *testing.T) {
+	var endpoint string
+	a := newTestServer(t, redirectTo(&endpoint))
+	endpoint = "ws" + strings.TrimPrefix(a.URL, "http")
+
+	c := NewJSONRPCSignalClient(context.Background()).(*JSONRPCSignalClient)
+	closed, err := c.Open(a.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Join("test", &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer}); !errors.Is(err, errTooManyRedirects) {
+		t.Errorf("join redirected in a loop = %v, want %v", err, errTooManyRedirects)
+	}
+	if got := len(a.requested()); got != maxJoinRedirects+1 {
+		t.Errorf("%v websockets opened, want %v", got, maxJoinRedirects+1)
+	}
+	_ = c.Close()
+	waitClosed(t, closed)
+}
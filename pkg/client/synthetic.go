@@ -0,0 +1,160 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lucsky/cuid"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// syntheticKeyframeInterval is how often the synthetic video sends an IDR frame
+	syntheticKeyframeInterval = 2 * time.Second
+	syntheticAudioFrame       = 20 * time.Millisecond
+)
+
+// SyntheticConfig sets the rate of a SyntheticProducer's media, zero values use defaults
+type SyntheticConfig struct {
+	// VideoBitrate and AudioBitrate in bits per second
+	VideoBitrate int
+	AudioBitrate int
+	FrameRate    int
+}
+
+// SyntheticProducer publishes generated h264 and opus samples without encoding anything.
+// The payloads are random bytes in valid NAL unit / frame framing, so they are forwarded
+// like real media but can't be decoded, for load testing without gstreamer.
+type SyntheticProducer struct {
+	conf       SyntheticConfig
+	audioTrack *webrtc.TrackLocalStaticSample
+	videoTrack *webrtc.TrackLocalStaticSample
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSyntheticProducer creates a producer of generated media at the rates in conf
+func NewSyntheticProducer(conf SyntheticConfig) (*SyntheticProducer, error) {
+	if conf.VideoBitrate <= 0 {
+		conf.VideoBitrate = 500000
+	}
+	if conf.AudioBitrate <= 0 {
+		conf.AudioBitrate = 32000
+	}
+	if conf.FrameRate <= 0 {
+		conf.FrameRate = 30
+	}
+
+	stream := fmt.Sprintf("synthetic-%v", cuid.New())
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeTypeH264, ClockRate: 90000}, cuid.New(), stream)
+	if err != nil {
+		return nil, err
+	}
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeTypeOpus, ClockRate: 48000}, cuid.New(), stream)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyntheticProducer{
+		conf:       conf,
+		audioTrack: audioTrack,
+		videoTrack: videoTrack,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+//AudioTrack returns the synthetic audio track
+func (p *SyntheticProducer) AudioTrack() *webrtc.TrackLocalStaticSample {
+	return p.audioTrack
+}
+
+//VideoTrack returns the synthetic video track
+func (p *SyntheticProducer) VideoTrack() *webrtc.TrackLocalStaticSample {
+	return p.videoTrack
+}
+
+//Start writing samples until Stop
+func (p *SyntheticProducer) Start() {
+	go p.writeAudio()
+	p.writeVideo()
+}
+
+//Stop writing samples
+func (p *SyntheticProducer) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+func (p *SyntheticProducer) writeVideo() {
+	interval := time.Second / time.Duration(p.conf.FrameRate)
+	frameSize := p.conf.VideoBitrate / 8 / p.conf.FrameRate
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastKeyframe := time.Time{}
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			var frame []byte
+			if now.Sub(lastKeyframe) >= syntheticKeyframeInterval {
+				// SPS, PPS and an IDR slice, so the sfu sees a keyframe
+				frame = append(frame, syntheticNAL(0x67, 16)...)
+				frame = append(frame, syntheticNAL(0x68, 4)...)
+				frame = append(frame, syntheticNAL(0x65, frameSize*3)...)
+				lastKeyframe = now
+			} else {
+				frame = syntheticNAL(0x41, frameSize)
+			}
+			if err := p.videoTrack.WriteSample(media.Sample{Data: frame, Duration: interval}); err != nil {
+				log.Error(err, "synthetic video write error")
+				return
+			}
+		}
+	}
+}
+
+func (p *SyntheticProducer) writeAudio() {
+	frameSize := p.conf.AudioBitrate / 8 / int(time.Second/syntheticAudioFrame)
+	ticker := time.NewTicker(syntheticAudioFrame)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			frame := make([]byte, frameSize+1)
+			// opus TOC for a 20ms CELT fullband frame
+			frame[0] = 0xfc
+			rand.Read(frame[1:])
+			if err := p.audioTrack.WriteSample(media.Sample{Data: frame, Duration: syntheticAudioFrame}); err != nil {
+				log.Error(err, "synthetic audio write error")
+				return
+			}
+		}
+	}
+}
+
+// syntheticNAL is an annex-b NAL unit with header and size bytes of random payload
+func syntheticNAL(header byte, size int) []byte {
+	if size < 1 {
+		size = 1
+	}
+	nal := make([]byte, 5+size)
+	copy(nal, []byte{0, 0, 0, 1, header})
+	rand.Read(nal[5:])
+	// Keep the random payload from containing a start code
+	for i := 5; i < len(nal); i++ {
+		if nal[i] <= 3 {
+			nal[i] = 0xff
+		}
+	}
+	return nal
+}
@@ -2,7 +2,10 @@ package cluster
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	logr "github.com/pion/ion-sfu/pkg/logger"
@@ -21,13 +24,18 @@ type RootConfig struct {
 	Signal      SignalConfig
 	SFU         sfu.Config
 	Coordinator CoordinatorConfig
+	Webhooks    WebhookConfig
+	Events      EventsConfig
+	Metrics     MetricsConfig
+	Tracing     TracingConfig
+	Log         LogConfig
 }
 
 // Endpoint public endpoint to hit
 func (c *RootConfig) Endpoint() string {
 	port := strings.Split(c.Signal.HTTPAddr, ":")[1]
 
-	if c.Signal.Key != "" && c.Signal.Cert != "" {
+	if len(c.Signal.certPairs()) > 0 {
 		return fmt.Sprintf("wss://%v:%v/ws", c.Signal.FQDN, port)
 	}
 	return fmt.Sprintf("ws://%v:%v/ws", c.Signal.FQDN, port)
@@ -40,22 +48,135 @@ type SignalConfig struct {
 	Cert     string
 	HTTPAddr string
 	GRPCAddr string
-	Auth     AuthConfig
+	// Certs are served alongside Cert/Key, picked by the server name the client asks for
+	Certs []CertConfig
+	// AllowedOrigins for browser websockets and CORS, exact or wildcard (https://*.example.com).
+	// Empty allows websockets from any origin but no CORS, "*" allows any origin for both
+	AllowedOrigins []string
+	Auth           AuthConfig
+	Limits         LimitsConfig
+	RateLimit      RateLimitConfig
+	Admin          AdminConfig
+
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout of the http server, zero is no timeout.
+	// Websockets clear them once upgraded.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// ResumeGracePeriod keeps a peer alive after its websocket drops so it can resume, zero disables resume
+	ResumeGracePeriod time.Duration
+	// ICERestartAttempts is how many ice restarts a peer gets before it is disconnected, zero disables ice restart
+	ICERestartAttempts int
+	// DrainGracePeriod is how long peers get to leave a draining node before they are
+	// disconnected with the node_draining reason
+	DrainGracePeriod time.Duration
+}
+
+// certPairs is Cert/Key followed by the additional SNI certificates
+func (c SignalConfig) certPairs() []CertConfig {
+	var pairs []CertConfig
+	if c.Cert != "" && c.Key != "" {
+		pairs = append(pairs, CertConfig{Cert: c.Cert, Key: c.Key})
+	}
+	return append(pairs, c.Certs...)
+}
+
+//CertConfig params for a tls certificate and key file, reloaded when the files change
+type CertConfig struct {
+	Cert string
+	Key  string
+}
+
+//AdminConfig params for the admin listener serving metrics, pprof and the admin api
+type AdminConfig struct {
+	// Addr to listen on, bind it to loopback (127.0.0.1:7100) to keep it private, empty disables
+	Addr  string
+	Pprof bool
+	// Token the admin api requires as a bearer token. Without one signal.auth jwts with an
+	// "admin": true claim are accepted, and the admin api is refused when auth is disabled.
+	Token string
+}
+
+//LimitsConfig params for the capacity of this node, zero is unlimited
+type LimitsConfig struct {
+	// MaxSessions on this node, new sessions are sent to other nodes in a cluster once it is reached
+	MaxSessions int
+	// MaxPeers on this node, readyz fails once it is reached
+	MaxPeers           int
+	MaxPeersPerSession int
+	// MaxPublishTracks is checked against the audio and video sections of a peer's offers
+	MaxPublishTracks int
+	// MaxIngressBitrate of all published tracks on this node in bits per second
+	MaxIngressBitrate uint64
+}
+
+//RateLimitConfig params for throttling signaling clients, zero rates are unlimited
+type RateLimitConfig struct {
+	// UpgradesPerIP is the websocket upgrades per second allowed from one ip
+	UpgradesPerIP float64
+	UpgradeBurst  int
+	// MessagesPerConn is the JSON-RPC messages per second allowed on one websocket
+	MessagesPerConn float64
+	MessageBurst    int
+	// Methods limits individual JSON-RPC methods on one websocket, e.g. trickle
+	Methods map[string]MethodRateLimit
+	// MaxMessageSize of a websocket message in bytes, larger messages close the websocket
+	MaxMessageSize int64
+	// TrustForwardedFor takes the client ip from the last X-Forwarded-For hop, the one the
+	// proxy in front of the node added, only enable behind a proxy
+	TrustForwardedFor bool
+	// TrustedProxies are the ips or cidrs of the other cluster nodes and any load balancers,
+	// X-Forwarded-For is only read from them so sessions proxied between nodes are limited by
+	// the client's ip rather than the proxying node's
+	TrustedProxies []string
+}
+
+//MethodRateLimit params for the rate of one JSON-RPC method
+type MethodRateLimit struct {
+	Rate  float64
+	Burst int
 }
 
 //AuthConfig params for JWT token authentication
 type AuthConfig struct {
 	Enabled bool
+	// Key is the shared secret for HMAC, or the PEM encoded public key for RSA and ECDSA
 	Key     string
 	KeyType string
 }
 
+var errTokenSigningMethod = fmt.Errorf("Token signing method doesn't match the auth keytype")
+
+// verifyKey parses Key for the keytype
+func (a AuthConfig) verifyKey() (interface{}, error) {
+	switch strings.ToUpper(a.KeyType) {
+	case "RSA":
+		return jwt.ParseRSAPublicKeyFromPEM([]byte(a.Key))
+	case "ECDSA":
+		return jwt.ParseECPublicKeyFromPEM([]byte(a.Key))
+	case "", "HMAC":
+		return []byte(a.Key), nil
+	default:
+		return nil, fmt.Errorf("unsupported auth keytype %q", a.KeyType)
+	}
+}
+
 func (a AuthConfig) keyFunc(t *jwt.Token) (interface{}, error) {
-	switch a.KeyType {
-	//TODO: add more support for keytypes here
+	var ok bool
+	switch strings.ToUpper(a.KeyType) {
+	case "RSA":
+		_, ok = t.Method.(*jwt.SigningMethodRSA)
+	case "ECDSA":
+		_, ok = t.Method.(*jwt.SigningMethodECDSA)
 	default:
-		return []byte(a.Key), nil
+		_, ok = t.Method.(*jwt.SigningMethodHMAC)
+	}
+	if !ok {
+		return nil, errTokenSigningMethod
 	}
+	return a.verifyKey()
 }
 
 //CoordinatorConfig params for which coordinator to use
@@ -68,3 +189,116 @@ type CoordinatorConfig struct {
 		Hosts   []string
 	}
 }
+
+//WebhookConfig params for session lifecycle webhooks
+type WebhookConfig struct {
+	// URLs receive a POST for every event
+	URLs []string
+	// Secret signs the timestamp and body with HMAC-SHA256 in the X-Ion-Signature header
+	Secret string
+	// Events to send, empty sends every event
+	Events     []string
+	QueueSize  int
+	MaxRetries int
+	Timeout    time.Duration
+}
+
+//EventsConfig params for cluster event sinks
+type EventsConfig struct {
+	// File appends every event as a line of json, empty disables
+	File string
+}
+
+//MetricsConfig params for the per session / peer metrics, labels are opt in to bound cardinality
+type MetricsConfig struct {
+	// SessionLabels labels metrics by session id, otherwise they are aggregated for the node
+	SessionLabels bool
+	// PeerLabels also labels metrics by peer id, requires SessionLabels
+	PeerLabels bool
+	// TopSessions only labels the largest sessions, the rest are labeled "other", zero labels all
+	TopSessions int
+}
+
+//TracingConfig params for exporting OpenTelemetry traces to an OTLP/HTTP collector
+type TracingConfig struct {
+	Enabled bool
+	// Endpoint is the collector's OTLP/HTTP traces url, empty uses http://localhost:4318/v1/traces
+	Endpoint    string
+	ServiceName string
+	// SampleRatio of new traces to sample, zero samples every trace
+	SampleRatio float64
+}
+
+//LogConfig params for logging, reloadable at runtime
+type LogConfig struct {
+	// V is the verbosity, higher logs more
+	V int
+}
+
+// Validate checks for settings that can't work, it doesn't check that addresses or files are reachable
+func (c RootConfig) Validate() error {
+	if problems := c.Problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid config: %v", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Problems lists every setting Validate rejects
+func (c RootConfig) Problems() []string {
+	var problems []string
+
+	if (c.Coordinator.Local != nil) == (c.Coordinator.Etcd != nil) {
+		problems = append(problems, "exactly one of coordinator.local and coordinator.etcd must be configured")
+	}
+	if c.Coordinator.Etcd != nil && len(c.Coordinator.Etcd.Hosts) == 0 {
+		problems = append(problems, "coordinator.etcd.hosts is empty")
+	}
+
+	problems = append(problems, checkPortRange("sfu.webrtc.portrange", c.SFU.WebRTC.ICEPortRange)...)
+	problems = append(problems, checkPortRange("sfu.turn.portrange", c.SFU.Turn.PortRange)...)
+
+	problems = append(problems, checkAddr("signal.httpaddr", c.Signal.HTTPAddr)...)
+	problems = append(problems, checkAddr("signal.admin.addr", c.Signal.Admin.Addr)...)
+	if c.SFU.Turn.Enabled {
+		problems = append(problems, checkAddr("sfu.turn.address", c.SFU.Turn.Address)...)
+	}
+
+	if _, err := parseTrustedProxies(c.Signal.RateLimit.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Sprintf("signal.ratelimit.trustedproxies: %v", err))
+	}
+
+	if c.Signal.Auth.Enabled {
+		if c.Signal.Auth.Key == "" {
+			problems = append(problems, "signal.auth.key is required when auth is enabled")
+		} else if _, err := c.Signal.Auth.verifyKey(); err != nil {
+			problems = append(problems, fmt.Sprintf("signal.auth.key is invalid for keytype %q: %v", c.Signal.Auth.KeyType, err))
+		}
+	}
+
+	return problems
+}
+
+func checkPortRange(key string, r []uint16) []string {
+	if len(r) == 0 {
+		return nil
+	}
+	if len(r) != 2 || r[0] == 0 || r[0] > r[1] {
+		return []string{fmt.Sprintf("%v must be [min, max] with 0 < min <= max, got %v", key, r)}
+	}
+	return nil
+}
+
+// checkAddr checks a host:port listen address, empty addresses are disabled listeners
+func checkAddr(key, addr string) []string {
+	if addr == "" {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{fmt.Sprintf("%v %q is not host:port", key, addr)}
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return []string{fmt.Sprintf("%v %q has an invalid port", key, addr)}
+	}
+	return nil
+}
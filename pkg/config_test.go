@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRootConfigProblems(t *testing.T) {
+	valid := func() RootConfig {
+		var c RootConfig
+		c.Coordinator.Local = &struct{ Enabled bool }{true}
+		c.Signal.HTTPAddr = ":7000"
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *RootConfig)
+		want   []string
+	}{
+		{"valid", func(c *RootConfig) {}, nil},
+		{"no coordinator", func(c *RootConfig) {
+			c.Coordinator.Local = nil
+		}, []string{"exactly one of coordinator.local and coordinator.etcd must be configured"}},
+		{"both coordinators", func(c *RootConfig) {
+			c.Coordinator.Etcd = &struct {
+				Enabled bool
+				Hosts   []string
+			}{true, []string{"localhost:2379"}}
+		}, []string{"exactly one of coordinator.local and coordinator.etcd must be configured"}},
+		{"etcd without hosts", func(c *RootConfig) {
+			c.Coordinator.Local = nil
+			c.Coordinator.Etcd = &struct {
+				Enabled bool
+				Hosts   []string
+			}{Enabled: true}
+		}, []string{"coordinator.etcd.hosts is empty"}},
+		{"port ranges", func(c *RootConfig) {
+			c.SFU.WebRTC.ICEPortRange = []uint16{6000, 5000}
+			c.SFU.Turn.PortRange = []uint16{0, 100}
+		}, []string{
+			"sfu.webrtc.portrange must be [min, max] with 0 < min <= max, got [6000 5000]",
+			"sfu.turn.portrange must be [min, max] with 0 < min <= max, got [0 100]",
+		}},
+		{"addresses", func(c *RootConfig) {
+			c.Signal.HTTPAddr = "7000"
+			c.Signal.Admin.Addr = "127.0.0.1:70000"
+		}, []string{
+			`signal.httpaddr "7000" is not host:port`,
+			`signal.admin.addr "127.0.0.1:70000" has an invalid port`,
+		}},
+		{"turn address only checked when enabled", func(c *RootConfig) {
+			c.SFU.Turn.Address = "nope"
+		}, nil},
+		{"turn address", func(c *RootConfig) {
+			c.SFU.Turn.Enabled = true
+			c.SFU.Turn.Address = "nope"
+		}, []string{`sfu.turn.address "nope" is not host:port`}},
+		{"trusted proxies", func(c *RootConfig) {
+			c.Signal.RateLimit.TrustedProxies = []string{"10.0.0.0/8", "proxy"}
+		}, []string{`signal.ratelimit.trustedproxies: invalid ip "proxy"`}},
+		{"auth without key", func(c *RootConfig) {
+			c.Signal.Auth.Enabled = true
+		}, []string{"signal.auth.key is required when auth is enabled"}},
+		{"auth hmac key", func(c *RootConfig) {
+			c.Signal.Auth.Enabled = true
+			c.Signal.Auth.Key = "secret"
+		}, nil},
+		{"auth unsupported keytype", func(c *RootConfig) {
+			c.Signal.Auth.Enabled = true
+			c.Signal.Auth.KeyType = "EdDSA"
+			c.Signal.Auth.Key = "secret"
+		}, []string{`signal.auth.key is invalid for keytype "EdDSA": unsupported auth keytype "EdDSA"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(&c)
+			if got := c.Problems(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Problems() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/pborman/uuid"
 	"github.com/pion/ion-sfu/pkg/buffer"
 	"github.com/pion/ion-sfu/pkg/middlewares/datachannel"
 	"github.com/pion/ion-sfu/pkg/sfu"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -25,19 +29,39 @@ type sessionMeta struct {
 // Coordinator is responsible for managing sessions
 // and providing rpc connections to other nodes
 type coordinator interface {
-	getOrCreateSession(sessionID string) (*sessionMeta, error)
+	getOrCreateSession(ctx context.Context, sessionID string) (*sessionMeta, error)
+	getNodeID() string
+	getLocalSessions() []*Session
+	events() EventSink
+	healthCheck(ctx context.Context) error
+	reload(conf RootConfig)
+	load() NodeLoad
+	// drain stops this node taking new sessions
+	drain()
+	// onDrainRequest sets the handler for drain requests from the cluster
+	onDrainRequest(f func())
 	sfu.SessionProvider
 }
 
-// NewCoordinator configures coordinator for this node
-func NewCoordinator(conf RootConfig) (coordinator, error) {
-	if conf.Coordinator.Etcd != nil {
-		return newCoordinatorEtcd(conf)
+// NewCoordinator configures coordinator for this node, cluster events are emitted to sinks
+// along with any sinks (webhooks, events file) enabled in conf
+func NewCoordinator(conf RootConfig, sinks ...EventSink) (coordinator, error) {
+	var c coordinator
+	var err error
+	switch {
+	case conf.Coordinator.Etcd != nil:
+		c, err = newCoordinatorEtcd(conf, sinks)
+	case conf.Coordinator.Local != nil:
+		c, err = newCoordinatorLocal(conf, sinks)
+	default:
+		return nil, fmt.Errorf("error no coodinator configured")
 	}
-	if conf.Coordinator.Local != nil {
-		return newCoordinatorLocal(conf)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("error no coodinator configured")
+
+	registerStatsCollector(c, conf)
+	return c, nil
 }
 
 type localCoordinator struct {
@@ -48,9 +72,11 @@ type localCoordinator struct {
 	w            sfu.WebRTCTransportConfig
 	sessions     map[string]*Session
 	datachannels []*sfu.Datachannel
+	sink         EventSink
+	limits       LimitsConfig
 }
 
-func newCoordinatorLocal(conf RootConfig) (coordinator, error) {
+func newCoordinatorLocal(conf RootConfig, sinks []EventSink) (coordinator, error) {
 	if conf.SFU.BufferFactory == nil {
 		conf.SFU.BufferFactory = buffer.NewBufferFactory(conf.SFU.Router.MaxPacketTrack, log.WithName("buffer"))
 	}
@@ -58,46 +84,80 @@ func newCoordinatorLocal(conf RootConfig) (coordinator, error) {
 	dc := &sfu.Datachannel{Label: sfu.APIChannelLabel}
 	dc.Use(datachannel.SubscriberAPI)
 
+	nodeID := uuid.New()
+	events, err := newCoordinatorEvents(conf, nodeID, sinks)
+	if err != nil {
+		return nil, err
+	}
+
 	return &localCoordinator{
-		nodeID:       uuid.New(),
+		nodeID:       nodeID,
 		nodeEndpoint: conf.Endpoint(),
 		datachannels: []*sfu.Datachannel{dc},
 		sessions:     make(map[string]*Session),
 		w:            w,
+		sink:         events,
+		limits:       conf.Signal.Limits,
 	}, nil
 }
 
 func (c *localCoordinator) ensureSession(sessionID string) *Session {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.ensureSessionLocked(sessionID)
+}
 
+// ensureSessionLocked returns the session, creating it if needed, c.mu must be held
+func (c *localCoordinator) ensureSessionLocked(sessionID string) *Session {
 	if s, ok := c.sessions[sessionID]; ok {
 		return s
 	}
 
-	s := NewSession(sessionID, c.datachannels, c.w)
+	s := NewSession(sessionID, c.datachannels, c.w, c.sink)
 	s.OnClose(func() {
 		c.onSessionClosed(sessionID)
 	})
 	prometheusGaugeSessions.Inc()
+	c.sink.Emit(SessionCreated{SessionID: sessionID, NodeID: c.nodeID})
 
-	c.sessions[sessionID] = &s
-	return &s
+	c.sessions[sessionID] = s
+	return s
 }
 
 func (c *localCoordinator) GetSession(sid string) (sfu.Session, sfu.WebRTCTransportConfig) {
-	return c.ensureSession(sid), c.w
+	s := c.ensureSession(sid)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return s, c.w
 }
 
-func (c *localCoordinator) getOrCreateSession(sessionID string) (*sessionMeta, error) {
-	c.ensureSession(sessionID)
+func (c *localCoordinator) getOrCreateSession(ctx context.Context, sessionID string) (*sessionMeta, error) {
+	_, span := tracer.Start(ctx, "coordinator.getOrCreateSession", trace.WithAttributes(attribute.String("session.id", sessionID)))
+	defer span.End()
 
-	return &sessionMeta{
+	start := time.Now()
+	// The capacity check and the create happen under one lock, so concurrent new sessions
+	// can't all be admitted on the same load
+	c.mu.Lock()
+	if _, exists := c.sessions[sessionID]; !exists {
+		if err := loadOf(c.localSessionsLocked(), c.limits).admitSession(c.limits); err != nil {
+			c.mu.Unlock()
+			metricsObserveSessionLookup(start, nil, err)
+			spanError(span, err)
+			return nil, err
+		}
+	}
+	c.ensureSessionLocked(sessionID)
+	c.mu.Unlock()
+
+	meta := &sessionMeta{
 		SessionID:    sessionID,
 		NodeID:       c.nodeID,
 		NodeEndpoint: c.nodeEndpoint,
 		Redirect:     false,
-	}, nil
+	}
+	metricsObserveSessionLookup(start, meta, nil)
+	return meta, nil
 }
 
 func (c *localCoordinator) onSessionClosed(sessionID string) {
@@ -106,4 +166,67 @@ func (c *localCoordinator) onSessionClosed(sessionID string) {
 	log.Info("session closed", "sessionID", sessionID)
 	delete(c.sessions, sessionID)
 	prometheusGaugeSessions.Dec()
+	c.sink.Emit(SessionClosed{SessionID: sessionID, NodeID: c.nodeID})
+}
+
+func (c *localCoordinator) getNodeID() string {
+	return c.nodeID
+}
+
+func (c *localCoordinator) getLocalSessions() []*Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.localSessionsLocked()
+}
+
+// localSessionsLocked lists the sessions, c.mu must be held
+func (c *localCoordinator) localSessionsLocked() []*Session {
+	sessions := make([]*Session, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (c *localCoordinator) events() EventSink {
+	return c.sink
+}
+
+func (c *localCoordinator) healthCheck(ctx context.Context) error {
+	return nil
+}
+
+// reload applies the router config to new peers and the capacity limits
+func (c *localCoordinator) reload(conf RootConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.Router = conf.SFU.Router
+	c.limits = conf.Signal.Limits
+}
+
+func (c *localCoordinator) load() NodeLoad {
+	c.mu.Lock()
+	limits := c.limits
+	c.mu.Unlock()
+
+	load := loadOf(c.getLocalSessions(), limits)
+	load.NodeID = c.nodeID
+	load.NodeEndpoint = c.nodeEndpoint
+	return load
+}
+
+// drain is a no-op, there are no other nodes to take new sessions
+func (c *localCoordinator) drain() {}
+
+// onDrainRequest is a no-op, a single node is only drained through its admin api
+func (c *localCoordinator) onDrainRequest(f func()) {}
+
+// localSession returns the session on this node with id sid, or nil
+func localSession(c coordinator, sid string) *Session {
+	for _, s := range c.getLocalSessions() {
+		if s.ID() == sid {
+			return s
+		}
+	}
+	return nil
 }
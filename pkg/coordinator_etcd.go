@@ -11,10 +11,13 @@ import (
 	"github.com/pion/ion-sfu/pkg/buffer"
 	"github.com/pion/ion-sfu/pkg/middlewares/datachannel"
 	"github.com/pion/ion-sfu/pkg/sfu"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 )
 
 type etcdCoordinator struct {
@@ -27,9 +30,24 @@ type etcdCoordinator struct {
 	datachannels  []*sfu.Datachannel
 	localSessions map[string]*Session
 	sessionLeases map[string]context.CancelFunc
+	sink          EventSink
+	limits        LimitsConfig
+	draining      bool
+	onDrain       func()
 }
 
-func newCoordinatorEtcd(conf RootConfig) (*etcdCoordinator, error) {
+const (
+	// nodeLoadInterval is how often a node advertises its load under /node/{id}
+	nodeLoadInterval = 5 * time.Second
+	nodeLoadTTL      = 15
+	// drainRequestTTL keeps a /drain/{id} request around long enough to be seen in etcdctl
+	drainRequestTTL = 60
+	// redirectClaimTTL is how long a session redirected to another node waits for it to be
+	// claimed by that node
+	redirectClaimTTL = 30
+)
+
+func newCoordinatorEtcd(conf RootConfig, sinks []EventSink) (*etcdCoordinator, error) {
 	log.Info("creating etcd client")
 	cli, err := clientv3.New(clientv3.Config{
 		DialTimeout: time.Second * 3,
@@ -47,21 +65,48 @@ func newCoordinatorEtcd(conf RootConfig) (*etcdCoordinator, error) {
 	dc := &sfu.Datachannel{Label: sfu.APIChannelLabel}
 	dc.Use(datachannel.SubscriberAPI)
 
-	log.Info("created etcdCoordinator")
-	return &etcdCoordinator{
+	nodeID := uuid.New()
+	events, err := newCoordinatorEvents(conf, nodeID, sinks)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &etcdCoordinator{
 		client:        cli,
-		nodeID:        uuid.New(),
+		nodeID:        nodeID,
 		nodeEndpoint:  conf.Endpoint(),
 		w:             w,
 		datachannels:  []*sfu.Datachannel{dc},
 		sessionLeases: make(map[string]context.CancelFunc),
 		localSessions: make(map[string]*Session),
-	}, nil
+		sink:          events,
+		limits:        conf.Signal.Limits,
+	}
+	go e.advertiseLoad()
+	go e.watchDrainRequests()
+
+	log.Info("created etcdCoordinator")
+	return e, nil
 }
 
-func (e *etcdCoordinator) getOrCreateSession(sessionID string) (*sessionMeta, error) {
+func (e *etcdCoordinator) getOrCreateSession(ctx context.Context, sessionID string) (*sessionMeta, error) {
+	ctx, span := tracer.Start(ctx, "coordinator.getOrCreateSession", trace.WithAttributes(attribute.String("session.id", sessionID)))
+	defer span.End()
+
+	start := time.Now()
+	meta, err := e.lookupOrCreateSession(ctx, sessionID)
+	metricsObserveSessionLookup(start, meta, err)
+	if err != nil {
+		spanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Bool("session.redirect", meta.Redirect), attribute.String("node.id", meta.NodeID))
+	return meta, nil
+}
+
+func (e *etcdCoordinator) lookupOrCreateSession(ctx context.Context, sessionID string) (*sessionMeta, error) {
 	// This operation is only alloted 5 seconds to complete
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	s, err := concurrency.NewSession(e.client, concurrency.WithContext(ctx))
@@ -72,10 +117,16 @@ func (e *etcdCoordinator) getOrCreateSession(sessionID string) (*sessionMeta, er
 	// Acquire the lock for this sessionID
 	key := fmt.Sprintf("/session/%v", sessionID)
 	mu := concurrency.NewMutex(s, key)
+	lockStart := time.Now()
+	_, lockSpan := tracer.Start(ctx, "etcd.lock")
 	if err := mu.Lock(ctx); err != nil {
 		log.Error(err, "could not acquire session lock", "sessionID", sessionID)
+		spanError(lockSpan, err)
+		lockSpan.End()
 		return nil, err
 	}
+	lockSpan.End()
+	prometheusHistogramSessionLockWait.Observe(time.Since(lockStart).Seconds())
 	defer mu.Unlock(ctx)
 
 	// Check to see if sessionMeta exists for this key
@@ -97,13 +148,39 @@ func (e *etcdCoordinator) getOrCreateSession(sessionID string) (*sessionMeta, er
 		}
 		meta.Redirect = (meta.NodeID != e.nodeID)
 
+		// Another node redirected the session here, keep it alive under our own lease now
+		if !meta.Redirect && !e.ownsSession(sessionID) {
+			log.Info("claiming session redirected to this node", "sessionID", sessionID)
+			return e.claimSession(ctx, key, sessionID)
+		}
+
 		// return meta for session
 		return &meta, nil
 	}
 
-	// Session does not already exist, so lets take it
-	// @todo load balance here / be smarter
+	// Session does not already exist, so lets take it unless this node is full or draining
+	load := e.load()
+	if load.Draining {
+		return e.redirectToNodeWithCapacity(ctx, key, sessionID, errDraining)
+	}
+	if err := load.admitSession(e.currentLimits()); err != nil {
+		return e.redirectToNodeWithCapacity(ctx, key, sessionID, err)
+	}
+
+	return e.claimSession(ctx, key, sessionID)
+}
+
+// ownsSession returns true if this node holds the lease on the session's meta
+func (e *etcdCoordinator) ownsSession(sessionID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.sessionLeases[sessionID]
+	return ok
+}
 
+// claimSession stores the session meta for this node under a lease kept alive until the
+// session closes, the session lock must be held
+func (e *etcdCoordinator) claimSession(ctx context.Context, key, sessionID string) (*sessionMeta, error) {
 	// First lets create a lease for the sessionKey
 	lease, err := e.client.Grant(ctx, 1)
 	if err != nil {
@@ -145,18 +222,22 @@ func (e *etcdCoordinator) ensureSession(sessionID string) *Session {
 		return s
 	}
 
-	s := NewSession(sessionID, e.datachannels, e.w)
+	s := NewSession(sessionID, e.datachannels, e.w, e.sink)
 	s.OnClose(func() {
 		e.onSessionClosed(sessionID)
 	})
 	prometheusGaugeSessions.Inc()
+	e.sink.Emit(SessionCreated{SessionID: sessionID, NodeID: e.nodeID})
 
-	e.localSessions[sessionID] = &s
-	return &s
+	e.localSessions[sessionID] = s
+	return s
 }
 
 func (e *etcdCoordinator) GetSession(sid string) (sfu.Session, sfu.WebRTCTransportConfig) {
-	return e.ensureSession(sid), e.w
+	s := e.ensureSession(sid)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return s, e.w
 }
 
 func (e *etcdCoordinator) onSessionClosed(sessionID string) {
@@ -197,6 +278,200 @@ func (e *etcdCoordinator) onSessionClosed(sessionID string) {
 	// Delete localSession
 	delete(e.localSessions, sessionID)
 	prometheusGaugeSessions.Dec()
+	e.sink.Emit(SessionClosed{SessionID: sessionID, NodeID: e.nodeID})
 
 	log.Info("etcdCoordinator canceled session lease", "sessionID", sessionID)
 }
+
+func (e *etcdCoordinator) getNodeID() string {
+	return e.nodeID
+}
+
+func (e *etcdCoordinator) getLocalSessions() []*Session {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(e.localSessions))
+	for _, s := range e.localSessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (e *etcdCoordinator) events() EventSink {
+	return e.sink
+}
+
+// healthCheck reads a key from etcd the same way etcdctl endpoint health does,
+// a permission denied error still means the cluster answered
+func (e *etcdCoordinator) healthCheck(ctx context.Context) error {
+	_, err := e.client.Get(ctx, "health")
+	if err == nil || err == rpctypes.ErrPermissionDenied {
+		return nil
+	}
+	return err
+}
+
+func (e *etcdCoordinator) currentLimits() LimitsConfig {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.limits
+}
+
+// reload applies the router config to new peers and the capacity limits
+func (e *etcdCoordinator) reload(conf RootConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Router = conf.SFU.Router
+	e.limits = conf.Signal.Limits
+}
+
+func (e *etcdCoordinator) load() NodeLoad {
+	load := loadOf(e.getLocalSessions(), e.currentLimits())
+	load.NodeID = e.nodeID
+	load.NodeEndpoint = e.nodeEndpoint
+	e.mu.Lock()
+	load.Draining = e.draining
+	e.mu.Unlock()
+	return load
+}
+
+// drain sends new sessions to other nodes, and advertises it so they don't send any here
+func (e *etcdCoordinator) drain() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.draining = true
+}
+
+func (e *etcdCoordinator) onDrainRequest(f func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onDrain = f
+}
+
+// watchDrainRequests calls the drain handler when /drain/{id} is put for this node
+func (e *etcdCoordinator) watchDrainRequests() {
+	key := drainRequestKey(e.nodeID)
+	for resp := range e.client.Watch(context.Background(), key) {
+		for _, ev := range resp.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			e.mu.Lock()
+			f := e.onDrain
+			e.mu.Unlock()
+
+			log.Info("drain requested through etcd", "key", key)
+			if f != nil {
+				f()
+			} else {
+				e.drain()
+			}
+		}
+	}
+}
+
+func drainRequestKey(nodeID string) string {
+	return fmt.Sprintf("/drain/%v", nodeID)
+}
+
+// redirectToNodeWithCapacity sends a new session to the least loaded node that is not full.
+// The meta is stored for that node while the session lock is held, so later joins follow the
+// same redirect, and expires after redirectClaimTTL unless that node claims it when the client
+// connects. The session lock must be held.
+func (e *etcdCoordinator) redirectToNodeWithCapacity(ctx context.Context, key, sessionID string, full error) (*sessionMeta, error) {
+	gr, err := e.client.Get(ctx, "/node/", clientv3.WithPrefix())
+	if err != nil {
+		log.Error(err, "error listing nodes", "sessionID", sessionID)
+		return nil, full
+	}
+
+	var target *NodeLoad
+	for _, kv := range gr.Kvs {
+		var load NodeLoad
+		if err := json.Unmarshal(kv.Value, &load); err != nil {
+			log.Error(err, "error unmarshaling node load", "key", string(kv.Key))
+			continue
+		}
+		if load.NodeID == e.nodeID || load.Full || load.Draining {
+			continue
+		}
+		if target == nil || load.Peers < target.Peers {
+			target = &load
+		}
+	}
+
+	if target == nil {
+		log.Info("no node has capacity for session", "sessionID", sessionID)
+		return nil, full
+	}
+
+	meta := sessionMeta{
+		SessionID:    sessionID,
+		NodeID:       target.NodeID,
+		NodeEndpoint: target.NodeEndpoint,
+	}
+	lease, err := e.client.Grant(ctx, redirectClaimTTL)
+	if err != nil {
+		log.Error(err, "error acquiring lease for redirected session", "key", key)
+		return nil, err
+	}
+	payload, _ := json.Marshal(&meta)
+	if _, err := e.client.Put(ctx, key, string(payload), clientv3.WithLease(lease.ID)); err != nil {
+		log.Error(err, "error storing redirected session meta", "key", key)
+		return nil, err
+	}
+
+	log.Info("node full, redirecting new session", "sessionID", sessionID, "nodeID", target.NodeID)
+	meta.Redirect = true
+	return &meta, nil
+}
+
+// advertiseLoad keeps this node's load under /node/{id} while the process runs
+func (e *etcdCoordinator) advertiseLoad() {
+	key := fmt.Sprintf("/node/%v", e.nodeID)
+	for {
+		if err := e.putLoad(key); err != nil {
+			log.Error(err, "error advertising node load", "key", key)
+		}
+		time.Sleep(nodeLoadInterval)
+	}
+}
+
+func (e *etcdCoordinator) putLoad(key string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := e.client.Grant(ctx, nodeLoadTTL)
+	if err != nil {
+		return err
+	}
+	keepAlive, err := e.client.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return err
+	}
+
+	put := func() error {
+		payload, _ := json.Marshal(e.load())
+		_, err := e.client.Put(ctx, key, string(payload), clientv3.WithLease(lease.ID))
+		return err
+	}
+	if err := put(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(nodeLoadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-keepAlive:
+			if !ok {
+				return fmt.Errorf("lease for %v expired", key)
+			}
+		case <-ticker.C:
+			if err := put(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event is a typed cluster event emitted into an EventSink
+type Event interface {
+	EventType() string
+}
+
+// EventSink consumes cluster events, Emit is called inline by the emitter so it must not block
+type EventSink interface {
+	Emit(event Event)
+}
+
+// EventSinkCloser is a sink that delivers what it has queued and releases its resources on
+// Close, waiting at most until ctx is done. Events emitted after Close are dropped.
+type EventSinkCloser interface {
+	EventSink
+	Close(ctx context.Context) error
+}
+
+// SessionCreated is emitted when a session is created on this node
+type SessionCreated struct {
+	SessionID string `json:"session_id"`
+	NodeID    string `json:"node_id"`
+}
+
+// SessionClosed is emitted when the last peer leaves a session on this node
+type SessionClosed struct {
+	SessionID string `json:"session_id"`
+	NodeID    string `json:"node_id"`
+}
+
+// PeerJoined is emitted when a peer joins a session
+type PeerJoined struct {
+	SessionID string `json:"session_id"`
+	PeerID    string `json:"peer_id"`
+	UID       string `json:"uid,omitempty"`
+}
+
+// PeerLeft is emitted when a peer leaves a session
+type PeerLeft struct {
+	SessionID string `json:"session_id"`
+	PeerID    string `json:"peer_id"`
+	UID       string `json:"uid,omitempty"`
+}
+
+// TrackPublished is emitted when a peer publishes a track
+type TrackPublished struct {
+	SessionID string `json:"session_id"`
+	PeerID    string `json:"peer_id"`
+	UID       string `json:"uid,omitempty"`
+	TrackID   string `json:"track_id"`
+	StreamID  string `json:"stream_id"`
+	Kind      string `json:"kind"`
+}
+
+// TrackUnpublished is emitted for each of a peer's published tracks when it leaves
+type TrackUnpublished struct {
+	SessionID string `json:"session_id"`
+	PeerID    string `json:"peer_id"`
+	UID       string `json:"uid,omitempty"`
+	TrackID   string `json:"track_id"`
+	StreamID  string `json:"stream_id"`
+	Kind      string `json:"kind"`
+}
+
+// PresenceChanged is emitted when the presence of a session changes
+type PresenceChanged struct {
+	SessionID string                 `json:"session_id"`
+	PeerID    string                 `json:"peer_id"`
+	Revision  uint64                 `json:"revision"`
+	Meta      map[string]interface{} `json:"meta"`
+}
+
+// NodeDraining is emitted when this node stops accepting new sessions before shutting down
+type NodeDraining struct {
+	NodeID string `json:"node_id"`
+}
+
+// EventType implements Event
+func (SessionCreated) EventType() string { return "session_created" }
+
+// EventType implements Event
+func (SessionClosed) EventType() string { return "session_closed" }
+
+// EventType implements Event
+func (PeerJoined) EventType() string { return "peer_joined" }
+
+// EventType implements Event
+func (PeerLeft) EventType() string { return "peer_left" }
+
+// EventType implements Event
+func (TrackPublished) EventType() string { return "track_published" }
+
+// EventType implements Event
+func (TrackUnpublished) EventType() string { return "track_unpublished" }
+
+// EventType implements Event
+func (PresenceChanged) EventType() string { return "presence_changed" }
+
+// EventType implements Event
+func (NodeDraining) EventType() string { return "node_draining" }
+
+// EventFanOut emits every event to each of its sinks
+type EventFanOut struct {
+	mu    sync.RWMutex
+	sinks []EventSink
+}
+
+// NewEventFanOut creates a fan out to sinks
+func NewEventFanOut(sinks ...EventSink) *EventFanOut {
+	return &EventFanOut{sinks: sinks}
+}
+
+// Add a sink to the fan out
+func (f *EventFanOut) Add(sink EventSink) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sinks = append(f.sinks, sink)
+}
+
+// Emit implements EventSink
+func (f *EventFanOut) Emit(event Event) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, sink := range f.sinks {
+		sink.Emit(event)
+	}
+}
+
+// Close closes every sink that is an EventSinkCloser, returning the first error
+func (f *EventFanOut) Close(ctx context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var err error
+	for _, sink := range f.sinks {
+		if closer, ok := sink.(EventSinkCloser); ok {
+			if closeErr := closer.Close(ctx); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// ChannelEventSink delivers events on a buffered channel, events are dropped while it is full
+type ChannelEventSink struct {
+	C <-chan Event
+	c chan Event
+}
+
+// NewChannelEventSink creates a channel sink buffering size events
+func NewChannelEventSink(size int) *ChannelEventSink {
+	c := make(chan Event, size)
+	return &ChannelEventSink{C: c, c: c}
+}
+
+// Emit implements EventSink
+func (s *ChannelEventSink) Emit(event Event) {
+	select {
+	case s.c <- event:
+	default:
+		log.Error(nil, "event channel full, dropping event", "type", event.EventType())
+	}
+}
+
+// fileEventQueueSize is how many events a FileEventSink buffers while its writer catches up
+const fileEventQueueSize = 1024
+
+// FileEventSink appends every event to a file as a line of json. Events are queued and
+// written by a goroutine so emitters holding session or peer locks never wait on the disk,
+// they are dropped while the queue is full.
+type FileEventSink struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan fileEvent
+	// stop is closed when Close gives up waiting, the rest of the queue is dropped
+	stop     chan struct{}
+	done     chan struct{}
+	closeErr error
+
+	f   *os.File
+	enc *json.Encoder
+}
+
+type fileEvent struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Event Event     `json:"event"`
+}
+
+// NewFileEventSink opens (or creates) path for appending events
+func NewFileEventSink(path string) (*FileEventSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileEventSink{
+		queue: make(chan fileEvent, fileEventQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		f:     f,
+		enc:   json.NewEncoder(f),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Emit implements EventSink
+func (s *FileEventSink) Emit(event Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		log.Info("event file closed, dropping event", "type", event.EventType())
+		return
+	}
+	select {
+	case s.queue <- fileEvent{event.EventType(), time.Now().UTC(), event}:
+	default:
+		log.Error(nil, "event file queue full, dropping event", "type", event.EventType())
+	}
+}
+
+func (s *FileEventSink) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		select {
+		case <-s.stop:
+			continue
+		default:
+		}
+		if err := s.enc.Encode(e); err != nil {
+			log.Error(err, "error writing event", "type", e.Type)
+		}
+	}
+	s.closeErr = s.f.Close()
+}
+
+// Close stops taking events, writes the queued ones and closes the file. Once ctx is done
+// the events still queued are dropped.
+func (s *FileEventSink) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return s.closeErr
+	case <-ctx.Done():
+		close(s.stop)
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// newCoordinatorEvents builds the fan out for a coordinator from the config and any extra sinks
+func newCoordinatorEvents(conf RootConfig, nodeID string, sinks []EventSink) (*EventFanOut, error) {
+	events := NewEventFanOut(sinks...)
+
+	if len(conf.Webhooks.URLs) > 0 {
+		events.Add(newWebhookDispatcher(conf.Webhooks, nodeID))
+	}
+
+	if conf.Events.File != "" {
+		f, err := NewFileEventSink(conf.Events.File)
+		if err != nil {
+			return nil, err
+		}
+		events.Add(f)
+	}
+
+	return events, nil
+}
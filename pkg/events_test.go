@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileEventSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink, err := NewFileEventSink(path)
+	if err != nil {
+		t.Fatalf("opening event file: %v", err)
+	}
+
+	events := []Event{
+		SessionCreated{SessionID: "s1"},
+		PeerJoined{SessionID: "s1", PeerID: "p1", UID: "alice"},
+		PeerLeft{SessionID: "s1", PeerID: "p1"},
+		SessionClosed{SessionID: "s1"},
+	}
+	for _, e := range events {
+		sink.Emit(e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Close(ctx); err != nil {
+		t.Fatalf("closing event file: %v", err)
+	}
+	// Dropped rather than written to the closed file
+	sink.Emit(SessionCreated{SessionID: "s2"})
+	if err := sink.Close(ctx); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line struct {
+			Type  string          `json:"type"`
+			Event json.RawMessage `json:"event"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("decoding %q: %v", scanner.Text(), err)
+		}
+		got = append(got, line.Type)
+	}
+
+	if len(got) != len(events) {
+		t.Fatalf("got %v, want %v events", got, len(events))
+	}
+	for i, e := range events {
+		if got[i] != e.EventType() {
+			t.Errorf("line %v is %v, want %v", i, got[i], e.EventType())
+		}
+	}
+}
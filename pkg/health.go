@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+var errDraining = errors.New("node is draining")
+
+// HealthStatus body of the /healthz and /readyz responses
+type HealthStatus struct {
+	Status string `json:"status"`
+	NodeID string `json:"node_id"`
+	// Checks maps each readiness check to "ok" or the reason it failed
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// AddReadinessCheck adds a check that must pass for /readyz to report ready
+func (s *Signal) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	s.checks = append(s.checks, readinessCheck{name: name, check: check})
+}
+
+func (s *Signal) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+func (s *Signal) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "coordinator", check: s.c.healthCheck},
+		{name: "draining", check: func(context.Context) error {
+			if s.isDraining() {
+				return errDraining
+			}
+			return nil
+		}},
+		{name: "capacity", check: func(context.Context) error {
+			limits := s.conf().Limits
+			return loadOf(s.c.getLocalSessions(), limits).admitSession(limits)
+		}},
+	}
+
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
+	return append(checks, s.checks...)
+}
+
+// healthzHandler reports the process is alive
+func (s *Signal) healthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeHealthStatus(w, http.StatusOK, HealthStatus{Status: "ok", NodeID: s.c.getNodeID()})
+	})
+}
+
+// readyzHandler reports whether this node should receive new clients
+func (s *Signal) readyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		status := HealthStatus{Status: "ok", NodeID: s.c.getNodeID(), Checks: make(map[string]string)}
+		code := http.StatusOK
+		for _, c := range s.readinessChecks() {
+			if err := c.check(ctx); err != nil {
+				status.Checks[c.name] = err.Error()
+				status.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+				continue
+			}
+			status.Checks[c.name] = "ok"
+		}
+
+		writeHealthStatus(w, code, status)
+	})
+}
+
+func writeHealthStatus(w http.ResponseWriter, code int, status HealthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// TURNHealthCheck checks the embedded TURN server listening on address answers a STUN
+// binding request, or accepts connections when it is serving TLS
+func TURNHealthCheck(address string, tls bool) func(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return func(context.Context) error { return err }
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	target := net.JoinHostPort(host, port)
+
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		if tls {
+			conn, err := d.DialContext(ctx, "tcp", target)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+
+		conn, err := d.DialContext(ctx, "udp", target)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+
+		req := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+		if _, err := conn.Write(req.Raw); err != nil {
+			return err
+		}
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		res := &stun.Message{Raw: buf[:n]}
+		if err := res.Decode(); err != nil {
+			return err
+		}
+		if res.TransactionID != req.TransactionID {
+			return errors.New("turn: unexpected stun response")
+		}
+		return nil
+	}
+}
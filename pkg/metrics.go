@@ -2,6 +2,8 @@ package cluster
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -28,12 +30,103 @@ var (
 			Help: "Number of currently active proxied websockets on this node",
 		},
 	)
+
+	prometheusCounterWebhookDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ion_cluster_webhook_deliveries_total",
+			Help: "Number of webhook events by event and result (success, failure, dropped)",
+		},
+		[]string{"event", "result"},
+	)
+	prometheusGaugeWebhookQueue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ion_cluster_webhook_queue_length",
+			Help: "Number of webhook events waiting for delivery by url host",
+		},
+		[]string{"host"},
+	)
+	prometheusHistogramWebhookLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ion_cluster_webhook_delivery_duration_seconds",
+			Help:    "Duration of webhook delivery attempts",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	prometheusHistogramSignalLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ion_cluster_signal_request_duration_seconds",
+			Help:    "Duration of JSON-RPC signal requests by method",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+	prometheusCounterSignalErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ion_cluster_signal_errors_total",
+			Help: "Number of JSON-RPC signal error replies by method and code",
+		},
+		[]string{"method", "code"},
+	)
+	prometheusHistogramSessionLookup = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ion_cluster_session_lookup_duration_seconds",
+			Help:    "Duration of coordinator session lookups by outcome (local, redirect, error)",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"outcome"},
+	)
+	prometheusHistogramSessionLockWait = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ion_cluster_session_lock_wait_seconds",
+			Help:    "Time spent waiting on the etcd session lock",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	prometheusCounterWebsocketUpgrades = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ion_cluster_websocket_upgrades_total",
+			Help: "Number of websocket upgrades by result (success, failure)",
+		},
+		[]string{"result"},
+	)
+	prometheusCounterSignalThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ion_cluster_signal_throttled_total",
+			Help: "Number of throttled websocket upgrades and JSON-RPC messages by scope (ip, connection, method) and method",
+		},
+		[]string{"scope", "method"},
+	)
+	prometheusHistogramProxyDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ion_cluster_proxy_connection_duration_seconds",
+			Help:    "Duration of websockets proxied to other nodes",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+	)
 )
 
+// signalMethods are the JSON-RPC methods used as metric labels, anything else is "unknown"
+var signalMethods = map[string]bool{
+	"join": true, "offer": true, "answer": true, "trickle": true, "presence_set": true,
+	"subscribe": true, "unsubscribe": true, "pause": true, "unpause": true, "set_layer": true,
+	"restart_ice": true, "resume": true, "stats": true, "leave": true, "ping": true,
+}
+
 func init() {
 	prometheus.MustRegister(prometheusGaugeSessions)
 	prometheus.MustRegister(prometheusGaugeClients)
 	prometheus.MustRegister(prometheusGaugeProxyClients)
+	prometheus.MustRegister(prometheusCounterWebhookDeliveries)
+	prometheus.MustRegister(prometheusGaugeWebhookQueue)
+	prometheus.MustRegister(prometheusHistogramWebhookLatency)
+	prometheus.MustRegister(prometheusHistogramSignalLatency)
+	prometheus.MustRegister(prometheusCounterSignalErrors)
+	prometheus.MustRegister(prometheusHistogramSessionLookup)
+	prometheus.MustRegister(prometheusHistogramSessionLockWait)
+	prometheus.MustRegister(prometheusCounterWebsocketUpgrades)
+	prometheus.MustRegister(prometheusHistogramProxyDuration)
+	prometheus.MustRegister(prometheusCounterSignalThrottled)
 	prometheus.MustRegister(prometheus.NewBuildInfoCollector())
 }
 
@@ -57,3 +150,36 @@ func MetricsGetActiveClientsCount() int {
 	active := clientCount.GetGauge().GetValue() + proxyCount.GetGauge().GetValue()
 	return int(active)
 }
+
+func signalMethodLabel(method string) string {
+	if signalMethods[method] {
+		return method
+	}
+	return "unknown"
+}
+
+func metricsObserveSignalRequest(method string, start time.Time) {
+	prometheusHistogramSignalLatency.WithLabelValues(signalMethodLabel(method)).Observe(time.Since(start).Seconds())
+}
+
+func metricsCountSignalError(method string, code int64) {
+	prometheusCounterSignalErrors.WithLabelValues(signalMethodLabel(method), strconv.FormatInt(code, 10)).Inc()
+}
+
+func metricsObserveSessionLookup(start time.Time, meta *sessionMeta, err error) {
+	outcome := "local"
+	switch {
+	case err != nil:
+		outcome = "error"
+	case meta.Redirect:
+		outcome = "redirect"
+	}
+	prometheusHistogramSessionLookup.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+func metricsCountThrottled(scope, method string) {
+	if scope != "ip" {
+		method = signalMethodLabel(method)
+	}
+	prometheusCounterSignalThrottled.WithLabelValues(scope, method).Inc()
+}
@@ -0,0 +1,262 @@
+package cluster
+
+import (
+	"sort"
+
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const sessionLabelOther = "other"
+
+// statsCollector gathers per session / peer metrics from the local sessions at scrape time.
+// Labels are opt in, by default everything is aggregated for the node, and with session labels
+// enabled only the largest TopSessions sessions are labeled, the rest are counted as "other".
+type statsCollector struct {
+	c    coordinator
+	conf MetricsConfig
+	rtp  bool
+
+	peers      *prometheus.Desc
+	published  *prometheus.Desc
+	subscribed *prometheus.Desc
+	ingressBPS *prometheus.Desc
+	ingress    *prometheus.Desc
+	egress     *prometheus.Desc
+	lost       *prometheus.Desc
+	nack       *prometheus.Desc
+	pli        *prometheus.Desc
+	fir        *prometheus.Desc
+	rtt        *prometheus.Desc
+	jitter     *prometheus.Desc
+}
+
+type peerStats struct {
+	peers          int
+	published      int
+	subscribed     int
+	ingressBitrate uint64
+	ingressBytes   uint64
+	egressBytes    uint64
+	packetsLost    int64
+	nacks          uint64
+	plis           uint64
+	firs           uint64
+	rtt            float64
+	rttSamples     int
+	jitter         float64
+	jitterSamples  int
+}
+
+func (s *peerStats) add(o *peerStats) {
+	s.peers += o.peers
+	s.published += o.published
+	s.subscribed += o.subscribed
+	s.ingressBitrate += o.ingressBitrate
+	s.ingressBytes += o.ingressBytes
+	s.egressBytes += o.egressBytes
+	s.packetsLost += o.packetsLost
+	s.nacks += o.nacks
+	s.plis += o.plis
+	s.firs += o.firs
+	s.rtt += o.rtt
+	s.rttSamples += o.rttSamples
+	s.jitter += o.jitter
+	s.jitterSamples += o.jitterSamples
+}
+
+// registerStatsCollector registers the session stats for c, rtp stats are only gathered with sfu withstats enabled
+func registerStatsCollector(c coordinator, conf RootConfig) {
+	if err := prometheus.Register(newStatsCollector(c, conf.Metrics, conf.SFU.SFU.WithStats)); err != nil {
+		log.Error(err, "error registering session stats collector")
+	}
+}
+
+func newStatsCollector(c coordinator, conf MetricsConfig, withStats bool) *statsCollector {
+	var labels []string
+	if conf.SessionLabels {
+		labels = append(labels, "session")
+		if conf.PeerLabels {
+			labels = append(labels, "peer")
+		}
+	}
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, labels, nil)
+	}
+
+	return &statsCollector{
+		c:    c,
+		conf: conf,
+		rtp:  withStats,
+
+		peers:      desc("ion_cluster_session_peers", "Number of peers in local sessions"),
+		published:  desc("ion_cluster_published_tracks", "Number of tracks published by peers"),
+		subscribed: desc("ion_cluster_subscribed_tracks", "Number of tracks forwarded to peers"),
+		ingressBPS: desc("ion_cluster_ingress_bitrate", "Ingress bitrate of published tracks in bits per second"),
+		ingress:    desc("ion_cluster_ingress_bytes", "Bytes received from the publishers currently connected"),
+		egress:     desc("ion_cluster_egress_bytes", "Bytes sent to the subscribers currently connected"),
+		lost:       desc("ion_cluster_packets_lost", "Packets lost from publishers and reported lost by subscribers currently connected"),
+		nack:       desc("ion_cluster_nacks", "NACKs received from the subscribers currently connected"),
+		pli:        desc("ion_cluster_plis", "PLIs received from the subscribers currently connected"),
+		fir:        desc("ion_cluster_firs", "FIRs received from the subscribers currently connected"),
+		rtt:        desc("ion_cluster_rtt_seconds", "Average round trip time to peers"),
+		jitter:     desc("ion_cluster_jitter_seconds", "Average jitter of published and subscribed streams"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (sc *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		sc.peers, sc.published, sc.subscribed, sc.ingressBPS, sc.ingress, sc.egress,
+		sc.lost, sc.nack, sc.pli, sc.fir, sc.rtt, sc.jitter,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (sc *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	sessions := sc.c.getLocalSessions()
+
+	// Largest sessions keep their label when the number of labeled sessions is capped
+	sort.Slice(sessions, func(i, j int) bool {
+		return len(sessions[i].Peers()) > len(sessions[j].Peers())
+	})
+
+	groups := make(map[[2]string]*peerStats)
+	for i, session := range sessions {
+		sessionLabel := session.ID()
+		if sc.conf.TopSessions > 0 && i >= sc.conf.TopSessions {
+			sessionLabel = sessionLabelOther
+		}
+
+		streamIDs := session.publishedStreamIDs()
+		for _, peer := range session.Peers() {
+			var key [2]string
+			if sc.conf.SessionLabels {
+				key[0] = sessionLabel
+				if sc.conf.PeerLabels && sessionLabel != sessionLabelOther {
+					key[1] = peer.ID()
+				}
+			}
+
+			g, ok := groups[key]
+			if !ok {
+				g = &peerStats{}
+				groups[key] = g
+			}
+			g.add(sc.peerStats(peer, streamIDs))
+		}
+	}
+
+	for key, g := range groups {
+		var labels []string
+		if sc.conf.SessionLabels {
+			labels = append(labels, key[0])
+			if sc.conf.PeerLabels {
+				labels = append(labels, key[1])
+			}
+		}
+
+		// The byte and packet totals are sums over the peers connected now, they drop as peers
+		// leave or sessions move into "other", so they are gauges rather than counters
+		gauge := func(d *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, labels...)
+		}
+
+		gauge(sc.peers, float64(g.peers))
+		gauge(sc.published, float64(g.published))
+		gauge(sc.subscribed, float64(g.subscribed))
+		gauge(sc.ingressBPS, float64(g.ingressBitrate))
+
+		if !sc.rtp {
+			continue
+		}
+		gauge(sc.ingress, float64(g.ingressBytes))
+		gauge(sc.egress, float64(g.egressBytes))
+		gauge(sc.lost, float64(g.packetsLost))
+		gauge(sc.nack, float64(g.nacks))
+		gauge(sc.pli, float64(g.plis))
+		gauge(sc.fir, float64(g.firs))
+		if g.rttSamples > 0 {
+			gauge(sc.rtt, g.rtt/float64(g.rttSamples))
+		}
+		if g.jitterSamples > 0 {
+			gauge(sc.jitter, g.jitter/float64(g.jitterSamples))
+		}
+	}
+}
+
+func (sc *statsCollector) peerStats(peer sfu.Peer, streamIDs []string) *peerStats {
+	s := &peerStats{peers: 1}
+
+	if pub := peer.Publisher(); pub != nil {
+		receivers := pub.GetRouter().GetReceiver()
+		s.published = len(receivers)
+		for _, recv := range receivers {
+			for _, bitrate := range recv.GetBitrate() {
+				s.ingressBitrate += bitrate
+			}
+		}
+		if sc.rtp {
+			s.addReport(pub.PeerConnection().GetStats())
+		}
+	}
+
+	if sub := peer.Subscriber(); sub != nil {
+		for _, streamID := range streamIDs {
+			s.subscribed += len(sub.GetDownTracks(streamID))
+		}
+		if sc.rtp {
+			s.addReport(sub.PeerConnection().GetStats())
+		}
+	}
+
+	return s
+}
+
+func (s *peerStats) addReport(report webrtc.StatsReport) {
+	for _, stat := range report {
+		switch stat := stat.(type) {
+		case webrtc.InboundRTPStreamStats:
+			s.ingressBytes += stat.BytesReceived
+			s.packetsLost += int64(stat.PacketsLost)
+			s.jitter += stat.Jitter
+			s.jitterSamples++
+		case webrtc.OutboundRTPStreamStats:
+			s.egressBytes += stat.BytesSent
+			s.nacks += uint64(stat.NACKCount)
+			s.plis += uint64(stat.PLICount)
+			s.firs += uint64(stat.FIRCount)
+		case webrtc.RemoteInboundRTPStreamStats:
+			s.packetsLost += int64(stat.PacketsLost)
+			s.jitter += stat.Jitter
+			s.jitterSamples++
+		case webrtc.ICECandidatePairStats:
+			if stat.Nominated && stat.CurrentRoundTripTime > 0 {
+				s.rtt += stat.CurrentRoundTripTime
+				s.rttSamples++
+			}
+		}
+	}
+}
+
+// publishedStreamIDs returns the stream ids of every track published in the session
+func (s *Session) publishedStreamIDs() []string {
+	seen := make(map[string]bool)
+	var streamIDs []string
+	for _, peer := range s.Peers() {
+		if peer.Publisher() == nil {
+			continue
+		}
+		for _, recv := range peer.Publisher().GetRouter().GetReceiver() {
+			if !seen[recv.StreamID()] {
+				seen[recv.StreamID()] = true
+				streamIDs = append(streamIDs, recv.StreamID())
+			}
+		}
+	}
+	return streamIDs
+}
@@ -0,0 +1,98 @@
+package cluster
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originAllowed checks origin against the allowed origins, which are either exact
+// (https://app.example.com), a wildcard subdomain (https://*.example.com) or "*".
+// No allowed origins accepts every origin.
+func originAllowed(allowed []string, origin string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+
+	for _, a := range allowed {
+		if a == "*" {
+			return true
+		}
+		pattern, err := url.Parse(strings.ToLower(a))
+		if err != nil || pattern.Scheme != scheme {
+			continue
+		}
+		if pattern.Host == host {
+			return true
+		}
+		if strings.HasPrefix(pattern.Host, "*.") && strings.HasSuffix(host, pattern.Host[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin is the websocket upgrader CheckOrigin, clients that don't send an origin
+// are not browsers and are always allowed
+func (s *Signal) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || originAllowed(s.conf().AllowedOrigins, origin) {
+		return true
+	}
+	log.Info("rejecting websocket from origin", "origin", origin)
+	return false
+}
+
+// corsOrigin is the Access-Control-Allow-Origin for a cross origin request, unlike
+// websockets CORS must be opted into, no allowed origins allows none and "*" allows any
+func corsOrigin(allowed []string, origin string) (string, bool) {
+	if len(allowed) == 0 {
+		return "", false
+	}
+	for _, a := range allowed {
+		if a == "*" {
+			return "*", true
+		}
+	}
+	if originAllowed(allowed, origin) {
+		return origin, true
+	}
+	return "", false
+}
+
+// cors adds CORS headers for allowed origins and answers preflight requests for h,
+// requests from other origins are refused before reaching h
+func (s *Signal) cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, ok := corsOrigin(s.conf().AllowedOrigins, origin)
+		if !ok {
+			log.V(1).Info("rejecting request from origin", "origin", origin, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
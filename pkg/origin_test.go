@@ -0,0 +1,61 @@
+package cluster
+
+import "testing"
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://app.example.com", "https://*.example.org", "http://localhost:3000"}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"none configured", nil, "https://anything.com", true},
+		{"star", []string{"*"}, "https://anything.com", true},
+		{"exact", allowed, "https://app.example.com", true},
+		{"exact case insensitive", allowed, "HTTPS://App.Example.com", true},
+		{"other host", allowed, "https://evil.example.com", false},
+		{"scheme mismatch", allowed, "http://app.example.com", false},
+		{"port mismatch", allowed, "http://localhost:3001", false},
+		{"port", allowed, "http://localhost:3000", true},
+		{"wildcard subdomain", allowed, "https://a.example.org", true},
+		{"wildcard nested subdomain", allowed, "https://a.b.example.org", true},
+		{"wildcard apex", allowed, "https://example.org", false},
+		{"wildcard suffix", allowed, "https://evilexample.org", false},
+		{"wildcard scheme mismatch", allowed, "http://a.example.org", false},
+		{"no host", allowed, "null", false},
+		{"unparsable", allowed, "https://%zz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originAllowed(tt.allowed, tt.origin); got != tt.want {
+				t.Errorf("originAllowed(%v, %q) = %v, want %v", tt.allowed, tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCORSOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    string
+		wantOK  bool
+	}{
+		{"none configured", nil, "https://app.example.com", "", false},
+		{"star", []string{"https://app.example.com", "*"}, "https://other.com", "*", true},
+		{"allowed", []string{"https://*.example.com"}, "https://app.example.com", "https://app.example.com", true},
+		{"scheme mismatch", []string{"https://*.example.com"}, "http://app.example.com", "", false},
+		{"refused", []string{"https://app.example.com"}, "https://other.com", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := corsOrigin(tt.allowed, tt.origin)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("corsOrigin(%v, %q) = %q, %v, want %q, %v", tt.allowed, tt.origin, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ipLimiterIdle is how long an ip's upgrade limiter is kept after its last upgrade
+	ipLimiterIdle = 10 * time.Minute
+	// throttleLogInterval limits logging of a throttled connection to once per interval
+	throttleLogInterval = 10 * time.Second
+)
+
+// limiter builds a token bucket for rps with burst, nil when the limit is disabled
+func limiter(rps float64, burst int) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+// clientIP is the address a request came from. X-Forwarded-For is only read from trusted
+// proxies, such as the other cluster nodes proxying sessions here, walking back from the
+// remote address through the hops they added until one isn't a trusted proxy. With
+// TrustForwardedFor the remote address is trusted as a proxy too, so the hop it added is
+// taken, never the ones before it which the client can write.
+func clientIP(r *http.Request, conf RateLimitConfig) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if conf.TrustForwardedFor && len(hops) > 0 {
+		ip, hops = hops[len(hops)-1], hops[:len(hops)-1]
+	}
+
+	if len(conf.TrustedProxies) == 0 {
+		return ip
+	}
+	trusted, _ := parseTrustedProxies(conf.TrustedProxies)
+	for len(hops) > 0 && ipInNets(ip, trusted) {
+		ip, hops = hops[len(hops)-1], hops[:len(hops)-1]
+	}
+	return ip
+}
+
+// parseTrustedProxies parses ips and cidrs, a plain ip is a single address network
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipInNets(addr string, nets []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type ipLimiterEntry struct {
+	limiter   *rate.Limiter
+	lastSeen  time.Time
+	throttled int
+	lastLog   time.Time
+}
+
+// ipLimiter limits websocket upgrades per client ip
+type ipLimiter struct {
+	mu        sync.Mutex
+	conf      RateLimitConfig
+	ips       map[string]*ipLimiterEntry
+	lastSweep time.Time
+}
+
+func newIPLimiter(conf RateLimitConfig) *ipLimiter {
+	return &ipLimiter{
+		conf:      conf,
+		ips:       make(map[string]*ipLimiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// setConfig replaces the upgrade limits, the per ip buckets start over
+func (l *ipLimiter) setConfig(conf RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conf = conf
+	l.ips = make(map[string]*ipLimiterEntry)
+}
+
+// allow takes a token for ip, always true when upgrade limits are disabled
+func (l *ipLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conf.UpgradesPerIP <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > ipLimiterIdle {
+		for ip, e := range l.ips {
+			if now.Sub(e.lastSeen) > ipLimiterIdle {
+				delete(l.ips, ip)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, ok := l.ips[ip]
+	if !ok {
+		e = &ipLimiterEntry{limiter: limiter(l.conf.UpgradesPerIP, l.conf.UpgradeBurst)}
+		l.ips[ip] = e
+	}
+	e.lastSeen = now
+	if e.limiter.Allow() {
+		return true
+	}
+
+	metricsCountThrottled("ip", "upgrade")
+	e.throttled++
+	if now.Sub(e.lastLog) >= throttleLogInterval {
+		log.Info("throttling websocket upgrades", "ip", ip, "throttled", e.throttled)
+		e.lastLog = now
+	}
+	return false
+}
+
+// connLimiter limits the JSON-RPC messages on one websocket, overall and per method
+type connLimiter struct {
+	ip      string
+	all     *rate.Limiter
+	methods map[string]*rate.Limiter
+
+	throttled int
+	lastLog   time.Time
+}
+
+func newConnLimiter(conf RateLimitConfig, ip string) *connLimiter {
+	l := &connLimiter{
+		ip:      ip,
+		all:     limiter(conf.MessagesPerConn, conf.MessageBurst),
+		methods: make(map[string]*rate.Limiter),
+	}
+	for method, m := range conf.Methods {
+		if ml := limiter(m.Rate, m.Burst); ml != nil {
+			l.methods[method] = ml
+		}
+	}
+	return l
+}
+
+// allow takes a token for method, returning the scope that throttled it otherwise.
+// Requests on one connection are handled in order, so this isn't locked.
+func (l *connLimiter) allow(method string) (string, bool) {
+	if ml, ok := l.methods[method]; ok && !ml.Allow() {
+		l.onThrottled("method", method)
+		return "method", false
+	}
+	if l.all != nil && !l.all.Allow() {
+		l.onThrottled("connection", method)
+		return "connection", false
+	}
+	return "", true
+}
+
+func (l *connLimiter) onThrottled(scope, method string) {
+	metricsCountThrottled(scope, method)
+	l.throttled++
+	if time.Since(l.lastLog) < throttleLogInterval {
+		return
+	}
+	log.Info("throttling signal client", "ip", l.ip, "scope", scope, "method", method, "throttled", l.throttled)
+	l.lastLog = time.Now()
+}
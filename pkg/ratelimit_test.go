@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	nodes := RateLimitConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.5"}}
+
+	for _, tc := range []struct {
+		name   string
+		conf   RateLimitConfig
+		remote string
+		fwd    []string
+		want   string
+	}{
+		{"remote addr", RateLimitConfig{}, "1.2.3.4:5000", nil, "1.2.3.4"},
+		{"forwarded for ignored", RateLimitConfig{}, "1.2.3.4:5000", []string{"5.6.7.8"}, "1.2.3.4"},
+		{"trust forwarded for", RateLimitConfig{TrustForwardedFor: true}, "1.2.3.4:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"trust forwarded for spoofed hop", RateLimitConfig{TrustForwardedFor: true}, "1.2.3.4:5000", []string{"6.6.6.6, 5.6.7.8"}, "5.6.7.8"},
+		{"trust forwarded for through node", RateLimitConfig{TrustForwardedFor: true, TrustedProxies: []string{"10.0.0.0/8"}}, "1.2.3.4:5000", []string{"6.6.6.6, 5.6.7.8, 10.4.5.6"}, "5.6.7.8"},
+		{"trust forwarded for without header", RateLimitConfig{TrustForwardedFor: true}, "1.2.3.4:5000", nil, "1.2.3.4"},
+		{"proxied by node", nodes, "10.1.2.3:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"proxied by node ip", nodes, "192.168.1.5:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"untrusted proxy", nodes, "192.168.1.6:5000", []string{"5.6.7.8"}, "192.168.1.6"},
+		{"spoofed hop before node", nodes, "10.1.2.3:5000", []string{"6.6.6.6, 5.6.7.8"}, "5.6.7.8"},
+		{"through two nodes", nodes, "10.1.2.3:5000", []string{"5.6.7.8, 10.4.5.6"}, "5.6.7.8"},
+		{"split headers", nodes, "10.1.2.3:5000", []string{"5.6.7.8", "10.4.5.6"}, "5.6.7.8"},
+		{"only nodes", nodes, "10.1.2.3:5000", []string{"10.4.5.6"}, "10.4.5.6"},
+		{"node without header", nodes, "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"ipv6", RateLimitConfig{TrustedProxies: []string{"::1"}}, "[::1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/session/test", nil)
+			r.RemoteAddr = tc.remote
+			for _, fwd := range tc.fwd {
+				r.Header.Add("X-Forwarded-For", fwd)
+			}
+			if got := clientIP(r, tc.conf); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, tc := range []struct {
+		proxies []string
+		valid   bool
+	}{
+		{nil, true},
+		{[]string{"10.0.0.1", "10.0.0.0/8", "::1", "fd00::/8"}, true},
+		{[]string{"10.0.0.256"}, false},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"node-1.example.com"}, false},
+	} {
+		if _, err := parseTrustedProxies(tc.proxies); (err == nil) != tc.valid {
+			t.Errorf("parseTrustedProxies(%v) error %v, want valid %v", tc.proxies, err, tc.valid)
+		}
+	}
+}
+
+func TestIPLimiter(t *testing.T) {
+	l := newIPLimiter(RateLimitConfig{UpgradesPerIP: 0.001, UpgradeBurst: 2})
+	for i, want := range []bool{true, true, false, false} {
+		if got := l.allow("1.2.3.4"); got != want {
+			t.Errorf("upgrade %v allowed %v, want %v", i, got, want)
+		}
+	}
+	if !l.allow("5.6.7.8") {
+		t.Error("another ip was throttled")
+	}
+
+	l.setConfig(RateLimitConfig{})
+	for i := 0; i < 10; i++ {
+		if !l.allow("1.2.3.4") {
+			t.Fatal("throttled with upgrade limits disabled")
+		}
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(RateLimitConfig{
+		MessagesPerConn: 0.001,
+		MessageBurst:    4,
+		Methods:         map[string]MethodRateLimit{"trickle": {Rate: 0.001, Burst: 1}, "offer": {}},
+	}, "1.2.3.4")
+
+	for i, tc := range []struct {
+		method string
+		scope  string
+		ok     bool
+	}{
+		{"trickle", "", true},
+		{"trickle", "method", false},
+		{"offer", "", true},
+		{"join", "", true},
+		{"join", "", true},
+		{"join", "connection", false},
+	} {
+		scope, ok := l.allow(tc.method)
+		if scope != tc.scope || ok != tc.ok {
+			t.Errorf("message %v (%v) got %q %v, want %q %v", i, tc.method, scope, ok, tc.scope, tc.ok)
+		}
+	}
+}
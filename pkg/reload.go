@@ -0,0 +1,236 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	logr "github.com/pion/ion-sfu/pkg/logger"
+)
+
+// reloadableKeys are the config keys (or key prefixes) applied at runtime by Reload,
+// everything else needs a restart
+var reloadableKeys = []string{
+	"signal.allowedorigins",
+	"signal.admin.token",
+	"signal.auth.",
+	"signal.limits.",
+	"signal.ratelimit.",
+	"signal.icerestartattempts",
+	"sfu.router.",
+	"log.",
+}
+
+// notReloadableKeys are under a reloadable prefix but only read at startup
+var notReloadableKeys = []string{
+	"sfu.router.maxpackettrack",
+}
+
+// secretKeys have their values redacted from diffs and printed configs
+var secretKeys = []string{
+	"signal.auth.key",
+	"signal.admin.token",
+	"webhooks.secret",
+	"sfu.turn.auth.secret",
+	"sfu.turn.auth.credentials",
+	"sfu.webrtc.iceserver.*.credential",
+}
+
+const redacted = "<redacted>"
+
+// ConfigChange is a config key that differs between two configs
+type ConfigChange struct {
+	Key string
+	Old string
+	New string
+}
+
+func (c ConfigChange) String() string {
+	return fmt.Sprintf("%v: %v -> %v", c.Key, c.Old, c.New)
+}
+
+// WithReloadable returns c with the runtime reloadable settings taken from next
+func (c RootConfig) WithReloadable(next RootConfig) RootConfig {
+	c.Signal.AllowedOrigins = next.Signal.AllowedOrigins
+	c.Signal.Auth = next.Signal.Auth
+	c.Signal.Admin.Token = next.Signal.Admin.Token
+	c.Signal.Limits = next.Signal.Limits
+	c.Signal.RateLimit = next.Signal.RateLimit
+	c.Signal.ICERestartAttempts = next.Signal.ICERestartAttempts
+
+	maxPacketTrack := c.SFU.Router.MaxPacketTrack
+	c.SFU.Router = next.SFU.Router
+	c.SFU.Router.MaxPacketTrack = maxPacketTrack
+
+	c.Log = next.Log
+	return c
+}
+
+// DiffConfig lists the settings that differ from old to next, split into the changes
+// Reload can apply and the ones that need a restart. Secrets are redacted.
+func DiffConfig(old, next RootConfig) (reloadable, restart []ConfigChange) {
+	before, after := FlattenConfig(old), FlattenConfig(next)
+
+	keys := make(map[string]bool)
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		if before[k] == after[k] {
+			continue
+		}
+		change := ConfigChange{Key: k, Old: before[k], New: after[k]}
+		if isSecretKey(k) {
+			change.Old, change.New = redacted, redacted
+		}
+		if isReloadableKey(k) {
+			reloadable = append(reloadable, change)
+		} else {
+			restart = append(restart, change)
+		}
+	}
+	return reloadable, restart
+}
+
+func isReloadableKey(key string) bool {
+	for _, k := range notReloadableKeys {
+		if key == k {
+			return false
+		}
+	}
+	for _, k := range reloadableKeys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretKey matches key against secretKeys, where * matches a list index
+func isSecretKey(key string) bool {
+	parts := strings.Split(key, ".")
+	for _, k := range secretKeys {
+		secret := strings.Split(k, ".")
+		if len(secret) != len(parts) {
+			continue
+		}
+		match := true
+		for i := range secret {
+			if secret[i] != "*" && secret[i] != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactedConfig is FlattenConfig of conf with the secrets that are set redacted
+func RedactedConfig(conf RootConfig) map[string]string {
+	flat := FlattenConfig(conf)
+	for k, v := range flat {
+		if v != "" && isSecretKey(k) {
+			flat[k] = redacted
+		}
+	}
+	return flat
+}
+
+// FlattenConfig maps every setting in conf to its dotted config key, as used by viper
+func FlattenConfig(conf interface{}) map[string]string {
+	flat := make(map[string]string)
+	flattenValue(reflect.ValueOf(conf), "", flat)
+	return flat
+}
+
+func flattenValue(v reflect.Value, key string, flat map[string]string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		flattenValue(v.Elem(), key, flat)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.ToLower(f.Name)
+			tag := f.Tag.Get("mapstructure")
+			if tag != "" {
+				// viper keys are case insensitive, sfu tags some fields in upper case
+				name = strings.ToLower(strings.Split(tag, ",")[0])
+			}
+			if name == "-" {
+				continue
+			}
+			if strings.Contains(tag, ",squash") {
+				flattenValue(v.Field(i), key, flat)
+				continue
+			}
+			if key != "" {
+				name = key + "." + name
+			}
+			flattenValue(v.Field(i), name, flat)
+		}
+	case reflect.Slice:
+		elem := v.Type().Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			flat[key] = fmt.Sprint(v.Interface())
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			flattenValue(v.Index(i), fmt.Sprintf("%v.%v", key, i), flat)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			flattenValue(iter.Value(), fmt.Sprintf("%v.%v", key, iter.Key()), flat)
+		}
+	case reflect.Func, reflect.Chan:
+	default:
+		flat[key] = fmt.Sprint(v.Interface())
+	}
+}
+
+// conf is the current signal config
+func (s *Signal) conf() SignalConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.config
+}
+
+// Reload applies the runtime reloadable settings from conf, auth, limits, origins and
+// rate limits apply to new requests and the router config to new peers
+func (s *Signal) Reload(conf RootConfig) {
+	s.configMu.Lock()
+	s.config = RootConfig{Signal: s.config}.WithReloadable(conf).Signal
+	s.configMu.Unlock()
+
+	s.upgrades.setConfig(conf.Signal.RateLimit)
+	s.c.reload(conf)
+	SetLogVerbosity(conf.Log.V)
+}
+
+// SetLogVerbosity sets the verbosity of every logger
+func SetLogVerbosity(v int) {
+	logr.SetGlobalOptions(logr.GlobalConfig{V: v})
+}
@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pion/ion-sfu/pkg/sfu"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	for _, tc := range []struct {
+		key    string
+		secret bool
+	}{
+		{"signal.auth.key", true},
+		{"signal.auth.keytype", false},
+		{"signal.admin.token", true},
+		{"signal.key", false},
+		{"webhooks.secret", true},
+		{"sfu.turn.auth.secret", true},
+		{"sfu.turn.auth.credentials", true},
+		{"sfu.webrtc.iceserver.0.credential", true},
+		{"sfu.webrtc.iceserver.12.credential", true},
+		{"sfu.webrtc.iceserver.0.username", false},
+		{"sfu.webrtc.iceserver.credential", false},
+		{"sfu.webrtc.iceserver.0.credential.x", false},
+	} {
+		if got := isSecretKey(tc.key); got != tc.secret {
+			t.Errorf("isSecretKey(%q) = %v, want %v", tc.key, got, tc.secret)
+		}
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	base := func() RootConfig {
+		var c RootConfig
+		c.Signal.HTTPAddr = ":7000"
+		c.Signal.Auth.Key = "old-key"
+		c.Signal.Limits.MaxPeers = 10
+		c.Signal.RateLimit.Methods = map[string]MethodRateLimit{"trickle": {Rate: 20, Burst: 50}}
+		c.SFU.Router.MaxBandwidth = 1500
+		c.SFU.Router.MaxPacketTrack = 500
+		c.SFU.WebRTC.ICEServers = []sfu.ICEServerConfig{{URLs: []string{"turn:a"}, Credential: "old"}}
+		c.Log.V = 1
+		return c
+	}
+
+	for _, tc := range []struct {
+		name       string
+		change     func(c *RootConfig)
+		reloadable []ConfigChange
+		restart    []ConfigChange
+	}{
+		{"unchanged", func(c *RootConfig) {}, nil, nil},
+		{
+			"reloadable",
+			func(c *RootConfig) {
+				c.Signal.Limits.MaxPeers = 20
+				c.SFU.Router.MaxBandwidth = 3000
+				c.Log.V = 2
+			},
+			[]ConfigChange{
+				{"log.v", "1", "2"},
+				{"sfu.router.maxbandwidth", "1500", "3000"},
+				{"signal.limits.maxpeers", "10", "20"},
+			},
+			nil,
+		},
+		{
+			"restart",
+			func(c *RootConfig) {
+				c.Signal.HTTPAddr = ":8000"
+				c.SFU.Router.MaxPacketTrack = 1000
+			},
+			nil,
+			[]ConfigChange{
+				{"sfu.router.maxpackettrack", "500", "1000"},
+				{"signal.httpaddr", ":7000", ":8000"},
+			},
+		},
+		{
+			"secrets redacted",
+			func(c *RootConfig) {
+				c.Signal.Auth.Key = "new-key"
+				c.SFU.WebRTC.ICEServers[0].Credential = "new"
+			},
+			[]ConfigChange{{"signal.auth.key", redacted, redacted}},
+			[]ConfigChange{{"sfu.webrtc.iceserver.0.credential", redacted, redacted}},
+		},
+		{
+			"map keys",
+			func(c *RootConfig) {
+				c.Signal.RateLimit.Methods = map[string]MethodRateLimit{"offer": {Rate: 2, Burst: 5}}
+			},
+			[]ConfigChange{
+				{"signal.ratelimit.methods.offer.burst", "", "5"},
+				{"signal.ratelimit.methods.offer.rate", "", "2"},
+				{"signal.ratelimit.methods.trickle.burst", "50", ""},
+				{"signal.ratelimit.methods.trickle.rate", "20", ""},
+			},
+			nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := base()
+			tc.change(&next)
+			reloadable, restart := DiffConfig(base(), next)
+			if !reflect.DeepEqual(reloadable, tc.reloadable) {
+				t.Errorf("reloadable %v, want %v", reloadable, tc.reloadable)
+			}
+			if !reflect.DeepEqual(restart, tc.restart) {
+				t.Errorf("restart %v, want %v", restart, tc.restart)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/jsonrpc2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxPendingNotifications bounds the notifications queued for a peer while its websocket is gone
+const maxPendingNotifications = 256
+
+// Resumable message sent after join, the token can be used with resume to reattach a new
+// websocket to the peer within the grace period after the websocket is lost
+type Resumable struct {
+	Token         string `json:"token"`
+	GracePeriodMS int64  `json:"grace_period_ms"`
+}
+
+// Resume message sent on a new websocket to reattach to a peer
+type Resume struct {
+	SID   string `json:"sid"`
+	Token string `json:"token"`
+}
+
+// resumeRegistry keeps peers alive for the grace period after their websocket is lost.
+// The websocket for a resume always connects to /session/{id}, so it is proxied to the
+// node owning the session, which is the only node holding the parked peer.
+type resumeRegistry struct {
+	mu     sync.Mutex
+	grace  time.Duration
+	parked map[string]*parkedPeer
+}
+
+type parkedPeer struct {
+	signal *JSONSignal
+	timer  *time.Timer
+}
+
+func newResumeRegistry(grace time.Duration) *resumeRegistry {
+	return &resumeRegistry{
+		grace:  grace,
+		parked: make(map[string]*parkedPeer),
+	}
+}
+
+// park keeps p alive for the grace period, after which it leaves its session
+func (r *resumeRegistry) park(p *JSONSignal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token := p.resumeToken
+	log.Info("parking peer for resume", "id", p.ID(), "grace", r.grace)
+	r.parked[token] = &parkedPeer{
+		signal: p,
+		timer: time.AfterFunc(r.grace, func() {
+			r.mu.Lock()
+			delete(r.parked, token)
+			r.mu.Unlock()
+
+			log.Info("resume grace period expired", "id", p.ID())
+			p.Leave()
+		}),
+	}
+}
+
+// take removes the parked peer for token
+func (r *resumeRegistry) take(token string) (*JSONSignal, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parked, ok := r.parked[token]
+	if !ok || !parked.timer.Stop() {
+		return nil, false
+	}
+	delete(r.parked, token)
+	return parked.signal, true
+}
+
+// signalConn handles requests for one websocket, forwarding them to the JSONSignal the
+// websocket is attached to, which is replaced by the parked peer on resume
+type signalConn struct {
+	mu      sync.Mutex
+	signal  *JSONSignal
+	limiter *connLimiter
+}
+
+func (c *signalConn) current() *JSONSignal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.signal
+}
+
+// Handle incoming RPC calls, resume is handled here and everything else by the attached JSONSignal
+func (c *signalConn) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	// Throttle before anything takes the peer's lock
+	if scope, ok := c.limiter.allow(req.Method); !ok {
+		if !req.Notif {
+			metricsCountSignalError(req.Method, 429)
+			_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
+				Code:    429,
+				Message: fmt.Sprintf("rate limit exceeded for %v", scope),
+			})
+		}
+		return
+	}
+
+	if req.Method != "resume" {
+		c.current().Handle(ctx, conn, req)
+		return
+	}
+
+	defer metricsObserveSignalRequest(req.Method, time.Now())
+
+	ctx, span := tracer.Start(ctx, "signal.resume", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	replyError := func(err error) {
+		metricsCountSignalError(req.Method, 500)
+		spanError(span, err)
+		_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
+			Code:    500,
+			Message: fmt.Sprintf("%s", err),
+		})
+	}
+
+	var resume Resume
+	if err := json.Unmarshal(*req.Params, &resume); err != nil {
+		log.Error(err, "resume: error parsing token")
+		replyError(err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.signal.resume == nil || c.signal.joined() {
+		replyError(fmt.Errorf("cannot resume on this connection"))
+		return
+	}
+
+	p, ok := c.signal.resume.take(resume.Token)
+	if !ok {
+		replyError(fmt.Errorf("unknown or expired resume token"))
+		return
+	}
+
+	p.mu.Lock()
+	sid := p.sid
+	p.mu.Unlock()
+	if sid != resume.SID || !p.joined() {
+		p.Leave()
+		replyError(fmt.Errorf("resume token is not valid for session %v", resume.SID))
+		return
+	}
+
+	// The peer now lives as long as the token this websocket was opened with
+	p.mu.Lock()
+	p.armTokenExpiry(c.signal.tokenExpires)
+	p.mu.Unlock()
+
+	// Drop the unused peer created for this websocket
+	c.signal.Leave()
+	c.signal = p
+
+	span.SetAttributes(attribute.String("session.id", sid), attribute.String("peer.id", p.ID()))
+	log.Info("peer resumed", "id", p.ID(), "sessionID", sid)
+	_ = conn.Reply(ctx, req.ID, true)
+	p.attach(ctx, conn)
+}
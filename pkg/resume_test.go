@@ -0,0 +1,203 @@
+package cluster
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestResumeRegistry(t *testing.T) {
+	s, _ := testSignal(t, RootConfig{}, nil)
+	parkedPeer := func(r *resumeRegistry, token string) *JSONSignal {
+		p := newJSONSignal(s, time.Time{})
+		p.resumeToken = token
+		r.park(p)
+		return p
+	}
+
+	r := newResumeRegistry(time.Minute)
+	p := parkedPeer(r, "a")
+	if _, ok := r.take("b"); ok {
+		t.Error("took an unknown token")
+	}
+	if got, ok := r.take("a"); !ok || got != p {
+		t.Fatalf("take = %v, %v, want the parked peer", got, ok)
+	}
+	if _, ok := r.take("a"); ok {
+		t.Error("took a token twice")
+	}
+
+	r = newResumeRegistry(10 * time.Millisecond)
+	parkedPeer(r, "a")
+	waitFor(t, "the grace period to expire", func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.parked) == 0
+	})
+	if _, ok := r.take("a"); ok {
+		t.Error("took a token after its grace period")
+	}
+}
+
+// resumeToken waits for the resumable notification sent after join
+func (p *testPeer) resumeToken() string {
+	p.t.Helper()
+	var resumable Resumable
+	waitFor(p.t, "resumable", func() bool { return len(p.notified("resumable")) > 0 })
+	if err := json.Unmarshal(p.notified("resumable")[0], &resumable); err != nil {
+		p.t.Fatal(err)
+	}
+	return resumable.Token
+}
+
+// dropTestPeer closes the peer's websocket and waits for the server to park it
+func dropTestPeer(t *testing.T, s *Signal, p *testPeer, token string) {
+	t.Helper()
+	p.conn.Close()
+	waitFor(t, "peer to be parked", func() bool {
+		s.resume.mu.Lock()
+		defer s.resume.mu.Unlock()
+		return s.resume.parked[token] != nil
+	})
+}
+
+// testSessionPeers is the number of peers in sid
+func testSessionPeers(s *Signal, sid string) int {
+	session := localSession(s.c, sid)
+	if session == nil {
+		return 0
+	}
+	return len(session.Peers())
+}
+
+func TestResume(t *testing.T) {
+	var conf RootConfig
+	conf.Signal.ResumeGracePeriod = time.Minute
+	s, srv := testSignal(t, conf, nil)
+
+	// A token for another session is spent, the peer leaves
+	p := dialTestPeer(t, srv, "resume", nil)
+	if err := p.join("resume", "a"); err != nil {
+		t.Fatal(err)
+	}
+	token := p.resumeToken()
+	dropTestPeer(t, s, p, token)
+	p.dial(srv, "resume", "")
+	if err := p.call("resume", Resume{SID: "other", Token: token}); err == nil || !strings.Contains(err.Error(), "not valid for session") {
+		t.Errorf("resume for another session = %v, want not valid for session", err)
+	}
+	waitFor(t, "peer to leave", func() bool { return testSessionPeers(s, "resume") == 0 })
+	if err := p.call("resume", Resume{SID: "resume", Token: token}); err == nil {
+		t.Error("resume with a spent token succeeded")
+	}
+
+	p = dialTestPeer(t, srv, "resume", nil)
+	if err := p.join("resume", "b"); err != nil {
+		t.Fatal(err)
+	}
+	token = p.resumeToken()
+	if err := p.call("resume", Resume{SID: "resume", Token: token}); err == nil || !strings.Contains(err.Error(), "cannot resume") {
+		t.Errorf("resume on a joined websocket = %v, want cannot resume", err)
+	}
+
+	dropTestPeer(t, s, p, token)
+	p.dial(srv, "resume", "")
+	if err := p.call("resume", Resume{SID: "resume", Token: "unknown"}); err == nil || !strings.Contains(err.Error(), "unknown or expired") {
+		t.Errorf("resume with an unknown token = %v, want unknown or expired", err)
+	}
+	if err := p.call("resume", Resume{SID: "resume", Token: token}); err != nil {
+		t.Fatal(err)
+	}
+	if got := testSessionPeers(s, "resume"); got != 1 {
+		t.Errorf("%v peers after resume, want 1", got)
+	}
+	if err := p.call("ping", nil); err != nil {
+		t.Errorf("ping after resume = %v", err)
+	}
+
+	// The peer is attached to one websocket at a time
+	other := dialTestPeer(t, srv, "resume", nil)
+	if err := other.call("resume", Resume{SID: "resume", Token: token}); err == nil {
+		t.Error("resumed the same peer twice")
+	}
+}
+
+func TestResumeGraceExpired(t *testing.T) {
+	var conf RootConfig
+	conf.Signal.ResumeGracePeriod = 100 * time.Millisecond
+	s, srv := testSignal(t, conf, nil)
+
+	p := dialTestPeer(t, srv, "expired", nil)
+	if err := p.join("expired", "a"); err != nil {
+		t.Fatal(err)
+	}
+	token := p.resumeToken()
+	dropTestPeer(t, s, p, token)
+	waitFor(t, "peer to leave", func() bool { return testSessionPeers(s, "expired") == 0 })
+
+	p.dial(srv, "expired", "")
+	if err := p.call("resume", Resume{SID: "expired", Token: token}); err == nil || !strings.Contains(err.Error(), "unknown or expired") {
+		t.Errorf("resume after the grace period = %v, want unknown or expired", err)
+	}
+}
+
+// TestResumeTokenExpiry checks the peer lives as long as the token it resumed with,
+// not the one it joined with
+func TestResumeTokenExpiry(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		join, resume   time.Duration
+		wantDisconnect bool
+	}{
+		{"resumed with a later token", 2 * time.Second, time.Hour, false},
+		{"resumed with an earlier token", time.Hour, 2 * time.Second, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var conf RootConfig
+			conf.Signal.ResumeGracePeriod = time.Minute
+			conf.Signal.Auth = AuthConfig{Enabled: true, Key: "secret"}
+			s, srv := testSignal(t, conf, nil)
+
+			accessToken := func(expiry time.Duration) string {
+				token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &authToken{
+					SID:            "expiry",
+					StandardClaims: &jwt.StandardClaims{ExpiresAt: time.Now().Add(expiry).Unix()},
+				}).SignedString([]byte("secret"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			}
+
+			p := dialTestPeerToken(t, srv, "expiry", accessToken(tc.join), nil)
+			if err := p.join("expiry", "a"); err != nil {
+				t.Fatal(err)
+			}
+			token := p.resumeToken()
+			dropTestPeer(t, s, p, token)
+			p.dial(srv, "expiry", accessToken(tc.resume))
+			if err := p.call("resume", Resume{SID: "expiry", Token: token}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.wantDisconnect {
+				waitFor(t, "token expiry disconnect", func() bool { return len(p.notified("disconnect")) > 0 })
+				var disconnect Disconnect
+				if err := json.Unmarshal(p.notified("disconnect")[0], &disconnect); err != nil || disconnect.Reason != DisconnectReasonTokenExpired {
+					t.Errorf("disconnect = %+v, %v, want %v", disconnect, err, DisconnectReasonTokenExpired)
+				}
+				return
+			}
+			time.Sleep(3 * time.Second)
+			if got := p.notified("disconnect"); len(got) != 0 {
+				t.Errorf("disconnected by the token joined with: %s", got)
+			}
+			if got := testSessionPeers(s, "expiry"); got != 1 {
+				t.Errorf("%v peers after the joining token expired, want 1", got)
+			}
+		})
+	}
+}
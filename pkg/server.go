@@ -1,50 +1,142 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/koding/websocketproxy"
 
-	"github.com/pion/ion-sfu/pkg/sfu"
 	"github.com/sourcegraph/jsonrpc2"
 	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
-
-	// pprof
-	_ "net/http/pprof"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Signal is the grpc/http/websocket signaling server
 type Signal struct {
-	c       coordinator
-	errChan chan error
+	c        coordinator
+	errChan  chan error
+	resume   *resumeRegistry
+	upgrades *ipLimiter
+	server   *http.Server
+	admin    *http.Server
+
+	// draining is set once Drain is called, readyz then fails
+	draining int32
+	checksMu sync.Mutex
+	checks   []readinessCheck
 
-	config SignalConfig
+	// configMu guards config, which is partly replaced by Reload
+	configMu sync.RWMutex
+	config   SignalConfig
 }
 
 // NewSignal creates a signaling server
 func NewSignal(c coordinator, conf SignalConfig) (*Signal, chan error) {
 	e := make(chan error)
 	w := &Signal{
-		c:       c,
-		errChan: e,
-		config:  conf,
+		c:        c,
+		errChan:  e,
+		resume:   newResumeRegistry(conf.ResumeGracePeriod),
+		upgrades: newIPLimiter(conf.RateLimit),
+		server: &http.Server{
+			Addr:              conf.HTTPAddr,
+			ReadHeaderTimeout: conf.ReadHeaderTimeout,
+			ReadTimeout:       conf.ReadTimeout,
+			WriteTimeout:      conf.WriteTimeout,
+			IdleTimeout:       conf.IdleTimeout,
+		},
+		admin: &http.Server{
+			Addr:              conf.Admin.Addr,
+			ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		},
+		config: conf,
 	}
+	c.onDrainRequest(w.Drain)
 	return w, e
 }
 
+// Shutdown stops the http and admin listeners and waits for open requests until ctx is done,
+// then flushes the event sinks. Websockets are hijacked so they aren't waited on, Drain and
+// wait for clients to leave first.
+func (s *Signal) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if adminErr := s.admin.Shutdown(ctx); err == nil {
+		err = adminErr
+	}
+	if events, ok := s.c.events().(EventSinkCloser); ok {
+		if eventsErr := events.Close(ctx); err == nil {
+			err = eventsErr
+		}
+	}
+	return err
+}
+
+// Drain announces this node is shutting down and waits for its clients to leave, those still
+// connected after the drain grace period are disconnected with the node_draining reason
+func (s *Signal) Drain() {
+	if !atomic.CompareAndSwapInt32(&s.draining, 0, 1) {
+		return
+	}
+	grace := s.conf().DrainGracePeriod
+	log.Info("node draining", "nodeID", s.c.getNodeID(), "gracePeriod", grace.String())
+	s.c.drain()
+	s.c.events().Emit(NodeDraining{NodeID: s.c.getNodeID()})
+
+	time.AfterFunc(grace, func() {
+		sessions := s.c.getLocalSessions()
+		log.Info("drain grace period over, disconnecting peers", "sessions", len(sessions))
+		for _, session := range sessions {
+			session.DisconnectAll(DisconnectReasonNodeDraining)
+		}
+	})
+}
+
 // ServeWebsocket listens for incoming websocket signaling requests
 func (s *Signal) ServeWebsocket() {
+	s.server.Handler = s.websocketHandler()
+
+	var err error
+	if pairs := s.conf().certPairs(); len(pairs) > 0 {
+		certs, certErr := NewCertReloader(pairs)
+		if certErr != nil {
+			s.errChan <- certErr
+			return
+		}
+		s.server.TLSConfig = certs.TLSConfig()
+
+		log.Info("Started JSONRPC Server (https)", "listen", s.conf().HTTPAddr, "certs", len(pairs))
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		log.Info("Started JSONRPC Server", "listen", s.conf().HTTPAddr)
+		err = s.server.ListenAndServe()
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		s.errChan <- err
+	}
+}
+
+// websocketHandler routes the session websockets and health checks
+func (s *Signal) websocketHandler() http.Handler {
 	r := mux.NewRouter()
 
+	if len(s.conf().AllowedOrigins) == 0 {
+		log.Info("no allowed origins configured, accepting websockets from any origin")
+	}
+
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:     s.checkOrigin,
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
@@ -53,8 +145,21 @@ func (s *Signal) ServeWebsocket() {
 		vars := mux.Vars(r)
 		sid := vars["id"]
 
-		if s.config.Auth.Enabled {
-			token, err := authGetAndValidateToken(s.config.Auth, r)
+		// Continue the trace from a proxying node, the span lasts as long as the websocket
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "signal.session", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attribute.String("session.id", sid)))
+		defer span.End()
+
+		conf := s.conf()
+		ip := clientIP(r, conf.RateLimit)
+		if !s.upgrades.allow(ip) {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		var tokenExpires time.Time
+		if conf.Auth.Enabled {
+			token, err := authGetAndValidateToken(conf.Auth, r)
 			if err != nil {
 				log.Error(err, "error authenticating token")
 				http.Error(w, "Invalid Token", http.StatusForbidden)
@@ -67,10 +172,20 @@ func (s *Signal) ServeWebsocket() {
 				http.Error(w, "Invalid Token", http.StatusForbidden)
 				return
 			}
+
+			if token.StandardClaims != nil && token.ExpiresAt != 0 {
+				tokenExpires = time.Unix(token.ExpiresAt, 0)
+			}
 		}
 
-		meta, err := s.c.getOrCreateSession(vars["id"])
+		meta, err := s.c.getOrCreateSession(ctx, vars["id"])
 		if err != nil {
+			spanError(span, err)
+			if code, ok := capacityErrorCode(err); ok {
+				log.Info("rejecting session, node at capacity", "sessionID", sid, "reason", err)
+				http.Error(w, err.Error(), code)
+				return
+			}
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -84,10 +199,16 @@ func (s *Signal) ServeWebsocket() {
 			}
 			proxy := websocketproxy.NewProxy(url)
 			proxy.Upgrader = &upgrader
+			proxy.Director = func(incoming *http.Request, out http.Header) {
+				otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(out))
+			}
+			span.SetAttributes(attribute.String("proxy.node.id", meta.NodeID))
 
 			log.Info("starting proxy for session", "sessionID", meta.SessionID, "nodeID", meta.NodeID, "endpoint", endpoint)
 			prometheusGaugeProxyClients.Inc()
+			proxyStart := time.Now()
 			proxy.ServeHTTP(w, r)
+			prometheusHistogramProxyDuration.Observe(time.Since(proxyStart).Seconds())
 			prometheusGaugeProxyClients.Dec()
 			log.Info("closed proxy for session", "sessionID", meta.SessionID, "nodeID", meta.NodeID, "endpoint", endpoint)
 			return
@@ -95,45 +216,82 @@ func (s *Signal) ServeWebsocket() {
 
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			panic(err)
+			// Upgrade has already replied, e.g. 403 for an origin that isn't allowed
+			prometheusCounterWebsocketUpgrades.WithLabelValues("failure").Inc()
+			log.V(1).Info("websocket upgrade failed", "sessionID", sid, "error", err.Error())
+			return
 		}
+		prometheusCounterWebsocketUpgrades.WithLabelValues("success").Inc()
 		defer c.Close()
-
-		prometheusGaugeClients.Inc()
-		p := JSONSignal{
-			sync.Mutex{},
-			s.c,
-			sfu.NewPeer(s.c),
-			"",
+		if conf.RateLimit.MaxMessageSize > 0 {
+			c.SetReadLimit(conf.RateLimit.MaxMessageSize)
 		}
-		defer p.Close()
 
-		jc := jsonrpc2.NewConn(r.Context(), websocketjsonrpc2.NewObjectStream(c), &p)
+		prometheusGaugeClients.Inc()
+		sc := &signalConn{signal: newJSONSignal(s, tokenExpires), limiter: newConnLimiter(conf.RateLimit, ip)}
+		jc := jsonrpc2.NewConn(ctx, websocketjsonrpc2.NewObjectStream(c), sc)
+		sc.current().attach(ctx, jc)
 		<-jc.DisconnectNotify()
 		prometheusGaugeClients.Dec()
+
+		// Keep the peer alive for a resume if the websocket dropped while in a session
+		if p := sc.current(); p.detach(jc) {
+			if s.resume.grace > 0 && p.joined() {
+				s.resume.park(p)
+			} else {
+				p.Leave()
+			}
+		}
 	}))
 
-	r.Handle("/metrics", metricsHandler())
+	r.Handle("/healthz", s.cors(s.healthzHandler()))
+	r.Handle("/readyz", s.cors(s.readyzHandler()))
 	r.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
-	http.Handle("/", r)
+	return r
+}
 
-	var err error
-	if s.config.Key != "" && s.config.Cert != "" {
-		log.Info("Started JSONRPC Server (https)", "listen", s.config.HTTPAddr)
-		err = http.ListenAndServeTLS(s.config.HTTPAddr, s.config.Cert, s.config.Key, nil)
-	} else {
-		log.Info("Started JSONRPC Server", "listen", s.config.HTTPAddr)
-		err = http.ListenAndServe(s.config.HTTPAddr, nil)
-	}
+// ServeAdmin listens for metrics, pprof and admin api requests on the admin address
+func (s *Signal) ServeAdmin() {
+	s.admin.Handler = s.adminHandler()
 
-	if err != nil {
+	if s.conf().Admin.Token == "" && !s.conf().Auth.Enabled {
+		log.Info("admin api disabled, set signal.admin.token or enable signal.auth to use it")
+	}
+	log.Info("Started admin server", "listen", s.conf().Admin.Addr, "pprof", s.conf().Admin.Pprof)
+	if err := s.admin.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		s.errChan <- err
 	}
 }
 
+// adminHandler routes metrics, pprof and the admin api
+func (s *Signal) adminHandler() http.Handler {
+	r := mux.NewRouter()
+
+	r.Use(noBrowsers)
+
+	r.Handle("/metrics", metricsHandler())
+	r.Handle("/admin/node", s.adminAuth(s.nodeHandler())).Methods(http.MethodGet)
+	r.Handle("/admin/node/drain", s.adminAuth(s.drainHandler())).Methods(http.MethodPost)
+	r.Handle("/admin/sessions", s.adminAuth(sessionsHandler(s.c))).Methods(http.MethodGet)
+	r.Handle("/admin/sessions/{sid}", s.adminAuth(sessionHandler(s.c))).Methods(http.MethodGet)
+	r.Handle("/admin/sessions/{sid}", s.adminAuth(closeSessionHandler(s.c))).Methods(http.MethodDelete)
+	r.Handle("/admin/sessions/{sid}/peers/{uid}", s.adminAuth(kickPeerHandler(s.c))).Methods(http.MethodDelete)
+	r.Handle("/admin/sessions/{sid}/peers/{uid}/stats", s.adminAuth(peerStatsHandler(s.c))).Methods(http.MethodGet)
+
+	if s.conf().Admin.Pprof {
+		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	}
+
+	return r
+}
+
 // // ServeGRPC serve grpc
 // func (s *Signal) ServeGRPC() {
 // 	l, err := net.Listen("tcp", s.config.GRPCAddr)
@@ -2,15 +2,19 @@ package cluster
 
 import (
 	// pprof
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
 var (
 	errorTokenClaimsInvalid = fmt.Errorf("Token claims invalid: must have SID")
+	errAdminClaimMissing    = errors.New("token has no admin claim")
+	errAdminAuthDisabled    = errors.New("admin api needs signal.admin.token or signal.auth to be set")
 )
 
 type authToken struct {
@@ -47,3 +51,43 @@ func authGetAndValidateToken(config AuthConfig, r *http.Request) (*authToken, er
 	}
 	return token.Claims.(*authToken), nil
 }
+
+// adminToken is a signal.auth jwt allowed to use the admin api
+type adminToken struct {
+	Admin bool `json:"admin"`
+	*jwt.StandardClaims
+}
+
+func (t *adminToken) Valid() error {
+	if !t.Admin {
+		return errAdminClaimMissing
+	}
+
+	if t.StandardClaims != nil {
+		return t.StandardClaims.Valid()
+	}
+	return nil
+}
+
+// authAdminRequest checks the request's bearer token against signal.admin.token,
+// or as a signal.auth jwt with an admin claim
+func authAdminRequest(conf SignalConfig, r *http.Request) error {
+	if conf.Admin.Token == "" && !conf.Auth.Enabled {
+		return errAdminAuthDisabled
+	}
+
+	bearer := r.Header.Get("Authorization")
+	if !strings.HasPrefix(bearer, "Bearer ") {
+		return errors.New("no bearer token")
+	}
+	tokenStr := strings.TrimPrefix(bearer, "Bearer ")
+
+	if conf.Admin.Token != "" && subtle.ConstantTimeCompare([]byte(tokenStr), []byte(conf.Admin.Token)) == 1 {
+		return nil
+	}
+	if !conf.Auth.Enabled {
+		return errors.New("invalid admin token")
+	}
+	_, err := jwt.ParseWithClaims(tokenStr, &adminToken{}, conf.Auth.keyFunc)
+	return err
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/pborman/uuid"
 	"github.com/pion/ion-sfu/pkg/sfu"
 	"github.com/pion/webrtc/v3"
 	"github.com/sourcegraph/jsonrpc2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Join message sent when initializing a peer connection
@@ -16,6 +20,8 @@ type Join struct {
 	SID   string                    `json:"sid"`
 	UID   string                    `json:"uid"`
 	Offer webrtc.SessionDescription `json:"offer"`
+	// NoAutoSubscribe stops the session from forwarding every track, use subscribe to pick streams
+	NoAutoSubscribe bool `json:"no_auto_subscribe"`
 }
 
 // Negotiation message sent when renegotiating the peer connection
@@ -23,6 +29,12 @@ type Negotiation struct {
 	Desc webrtc.SessionDescription `json:"desc"`
 }
 
+// Transport targets for trickle and restart_ice
+const (
+	TransportPublisher  = 0
+	TransportSubscriber = 1
+)
+
 // Trickle message sent when renegotiating the peer connection
 type Trickle struct {
 	Target    int                     `json:"target"`
@@ -34,24 +46,302 @@ type Presence struct {
 	Revision   uint64                 `json:"revision"`
 	Meta       map[string]interface{} `json:"meta"`
 	SystemInfo map[string]string      `json:"sysinfo"`
+	// Speakers are the active speakers at the time of the presence update, for late joiners
+	Speakers []Speaker `json:"speakers,omitempty"`
+}
+
+// RestartICE message sent to restart ice on a transport. The server sends it for the publisher
+// transport, the client should then send an offer with ice restart. The client sends it for
+// the subscriber transport, the server then sends an offer with ice restart. Asking for a
+// transport the server is already restarting is acknowledged and doesn't use up an attempt.
+// Restarts start when ice disconnects, once it has failed the sfu has closed the transport.
+type RestartICE struct {
+	Target int `json:"target"`
+}
+
+// DisconnectReason explains why the server is closing a client connection
+type DisconnectReason string
+
+const (
+	// DisconnectReasonKicked the peer was removed from the session
+	DisconnectReasonKicked DisconnectReason = "kicked"
+	// DisconnectReasonSessionClosed the session was closed
+	DisconnectReasonSessionClosed DisconnectReason = "session_closed"
+	// DisconnectReasonTokenExpired the access token used to connect expired
+	DisconnectReasonTokenExpired DisconnectReason = "token_expired"
+	// DisconnectReasonNodeDraining the node is shutting down
+	DisconnectReasonNodeDraining DisconnectReason = "node_draining"
+	// DisconnectReasonICEFailed the peer connection failed
+	DisconnectReasonICEFailed DisconnectReason = "ice_failed"
+)
+
+// Disconnect message sent to the client before the server closes the connection
+type Disconnect struct {
+	Reason DisconnectReason `json:"reason"`
 }
 
 type JSONSignal struct {
-	mu sync.Mutex
-	c  coordinator
+	mu     sync.Mutex
+	c      coordinator
+	signal *Signal
 	*sfu.PeerLocal
 
-	sid string
+	sid       string
+	uid       string
+	session   *Session
+	left      chan struct{}
+	published []TrackPublished
+
+	tokenExpires time.Time
+	expireTimer  *time.Timer
+
+	resume      *resumeRegistry
+	resumeToken string
+
+	iceRestarts int
+	// iceRestarting marks transports with an ice restart in flight, the publisher until the
+	// client's restart offer arrives and the subscriber until the client answers ours. A
+	// restart_ice for a transport that is already restarting is the same failure, not a new one.
+	iceRestarting [2]bool
+
+	// connMu guards the websocket the peer is attached to, notifications sent while
+	// detached are queued in pending and replayed on resume
+	connMu  sync.Mutex
+	conn    *jsonrpc2.Conn
+	connCtx context.Context
+	pending []Broadcast
+}
+
+func newJSONSignal(s *Signal, tokenExpires time.Time) *JSONSignal {
+	return &JSONSignal{
+		c:            s.c,
+		signal:       s,
+		PeerLocal:    sfu.NewPeer(s.c),
+		tokenExpires: tokenExpires,
+		resume:       s.resume,
+	}
+}
+
+// attach sets the websocket notifications are sent to, and flushes any pending notifications
+func (p *JSONSignal) attach(ctx context.Context, conn *jsonrpc2.Conn) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	p.conn = conn
+	p.connCtx = ctx
+	for _, msg := range p.pending {
+		if err := conn.Notify(ctx, msg.method, msg.params); err != nil {
+			log.Error(err, "error replaying notification", "id", p.ID(), "method", msg.method)
+		}
+	}
+	p.pending = nil
+}
+
+// detach clears the websocket if it is still conn, returns false if the peer was attached elsewhere
+func (p *JSONSignal) detach(conn *jsonrpc2.Conn) bool {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.conn != conn {
+		return false
+	}
+	p.conn = nil
+	p.connCtx = nil
+	return true
+}
+
+// notify sends a notification to the attached websocket, or queues it while detached
+func (p *JSONSignal) notify(method string, params interface{}) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.conn == nil {
+		if len(p.pending) >= maxPendingNotifications {
+			log.Error(nil, "dropping notification for detached peer", "id", p.ID(), "method", method)
+			return
+		}
+		p.pending = append(p.pending, Broadcast{method: method, params: params})
+		return
+	}
+
+	if err := p.conn.Notify(p.connCtx, method, params); err != nil {
+		log.Error(err, "error sending notification", "id", p.ID(), "method", method)
+	}
+}
+
+// closeConn closes the attached websocket, if any
+func (p *JSONSignal) closeConn() {
+	p.connMu.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.connCtx = nil
+	p.connMu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// leave removes the peer from its session and closes the peer connections, p.mu must be held
+func (p *JSONSignal) leave() {
+	if p.expireTimer != nil {
+		p.expireTimer.Stop()
+		p.expireTimer = nil
+	}
+
+	if p.session != nil {
+		p.session.speakers.removePeer(p.ID())
+		p.session.BroadcastRemoveListener(p.ID())
+		p.session.UpdatePresenceMetaForPeer(p.ID(), nil)
+		close(p.left)
+		for _, track := range p.published {
+			p.c.events().Emit(TrackUnpublished(track))
+		}
+		p.c.events().Emit(PeerLeft{SessionID: p.sid, PeerID: p.ID(), UID: p.uid})
+		p.published = nil
+		p.session = nil
+		p.sid = ""
+	}
+
+	if err := p.PeerLocal.Close(); err != nil {
+		log.Error(err, "error closing peer", "id", p.ID())
+	}
+}
+
+// Leave removes the peer from its session and closes the peer connections
+func (p *JSONSignal) Leave() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.leave()
+}
+
+// joined returns true while the peer is in a session
+func (p *JSONSignal) joined() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.session != nil
+}
+
+// armTokenExpiry disconnects the peer when the token it connected with expires, replacing
+// the timer of an earlier token. A zero time never expires. p.mu must be held
+func (p *JSONSignal) armTokenExpiry(expires time.Time) {
+	if p.expireTimer != nil {
+		p.expireTimer.Stop()
+		p.expireTimer = nil
+	}
+	p.tokenExpires = expires
+	if expires.IsZero() {
+		return
+	}
+	p.expireTimer = time.AfterFunc(time.Until(expires), func() {
+		p.disconnect(DisconnectReasonTokenExpired)
+	})
+}
+
+// iceRestartAllowed counts an ice restart attempt, returns false once attempts are exhausted, p.mu must be held
+func (p *JSONSignal) iceRestartAllowed() bool {
+	p.iceRestarts++
+	return p.iceRestarts <= p.signal.conf().ICERestartAttempts
+}
+
+// iceTransportsOpen returns false once the sfu has closed a transport, it can't be restarted then
+func (p *JSONSignal) iceTransportsOpen() bool {
+	if pub := p.Publisher(); pub != nil && pub.PeerConnection().ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return false
+	}
+	if sub := p.Subscriber(); sub != nil && sub.PeerConnection().ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return false
+	}
+	return true
+}
+
+// restartSubscriberICE sends the client a subscriber offer with ice restart, p.mu must be held
+func (p *JSONSignal) restartSubscriberICE() error {
+	sub := p.Subscriber()
+	if sub == nil {
+		return errNoSubscriber
+	}
+
+	pc := sub.PeerConnection()
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	p.notify("offer", &offer)
+	return nil
+}
+
+// onICEDisconnected restarts ice on both transports, or disconnects once restart attempts are
+// exhausted. The sfu closes a transport when its ice fails, so the restart has to start while it
+// is only disconnected. A subscriber restart the client already asked for is the same loss of
+// connectivity, so it isn't counted again.
+func (p *JSONSignal) onICEDisconnected() {
+	p.mu.Lock()
+	clientRestarting := p.session != nil && p.iceRestarting[TransportSubscriber] && !p.iceRestarting[TransportPublisher]
+	if p.session == nil || !p.iceTransportsOpen() || (!clientRestarting && !p.iceRestartAllowed()) {
+		p.mu.Unlock()
+		log.Info("peer ice disconnected, closing peer and websocket", "id", p.ID())
+		p.disconnect(DisconnectReasonICEFailed)
+		return
+	}
+	defer p.mu.Unlock()
+
+	log.Info("peer ice disconnected, restarting ice", "id", p.ID(), "attempt", p.iceRestarts)
+	p.iceRestarting[TransportPublisher] = true
+	p.notify("restart_ice", RestartICE{Target: TransportPublisher})
+	if clientRestarting {
+		return
+	}
+	p.iceRestarting[TransportSubscriber] = true
+	if err := p.restartSubscriberICE(); err != nil {
+		log.Error(err, "error restarting subscriber ice", "id", p.ID())
+	}
+}
+
+// disconnect tells the client why it is being disconnected, then leaves and closes the connection
+func (p *JSONSignal) disconnect(reason DisconnectReason) {
+	log.Info("disconnecting peer", "id", p.ID(), "reason", reason)
+	p.notify("disconnect", Disconnect{Reason: reason})
+	p.Leave()
+	p.closeConn()
+}
+
+// answer negotiates a publisher offer inside its own span
+func (p *JSONSignal) answer(ctx context.Context, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	_, span := tracer.Start(ctx, "sdp.answer")
+	defer span.End()
+	answer, err := p.Answer(offer)
+	if err != nil {
+		spanError(span, err)
+	}
+	return answer, err
 }
 
 // Handle incoming RPC call events like join, answer, offer and trickle
 func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	defer metricsObserveSignalRequest(req.Method, time.Now())
+
+	ctx, span := tracer.Start(ctx, "signal."+signalMethodLabel(req.Method), trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	span.SetAttributes(attribute.String("session.id", p.sid), attribute.String("peer.id", p.uid))
+
 	replyError := func(err error) {
+		code := int64(500)
+		if c, ok := capacityErrorCode(err); ok {
+			code = int64(c)
+		}
+		metricsCountSignalError(req.Method, code)
+		spanError(span, err)
 		_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
-			Code:    500,
+			Code:    code,
 			Message: fmt.Sprintf("%s", err),
 		})
 	}
@@ -66,7 +356,8 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			break
 		}
 
-		meta, err := p.c.getOrCreateSession(join.SID)
+		span.SetAttributes(attribute.String("session.id", join.SID), attribute.String("peer.id", join.UID))
+		meta, err := p.c.getOrCreateSession(ctx, join.SID)
 		if err != nil {
 			replyError(err)
 			break
@@ -75,6 +366,7 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		if meta.Redirect {
 			payload, _ := json.Marshal(meta)
 			// session exists on other node, let client know
+			metricsCountSignalError(req.Method, 302)
 			_ = conn.ReplyWithError(ctx, req.ID, &jsonrpc2.Error{
 				Code:    302,
 				Message: string(payload),
@@ -82,58 +374,102 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			break
 		}
 
-		err = p.Join(join.SID, join.UID)
-		if err != nil {
-			replyError(err)
-			break
-		}
-
-		answer, err := p.Answer(join.Offer)
-		if err != nil {
+		if err := p.admit(join); err != nil {
+			log.Info("rejecting join, over capacity", "sessionID", join.SID, "reason", err)
 			replyError(err)
 			break
 		}
 
+		// The sfu calls these from its own goroutines once joined, so they are set first
 		p.OnOffer = func(offer *webrtc.SessionDescription) {
-			if err := conn.Notify(ctx, "offer", offer); err != nil {
-				log.Error(err, "error sending offer")
-			}
+			p.notify("offer", offer)
 		}
 		p.OnIceCandidate = func(candidate *webrtc.ICECandidateInit, target int) {
-			if err := conn.Notify(ctx, "trickle", Trickle{
+			p.notify("trickle", Trickle{
 				Candidate: *candidate,
 				Target:    target,
-			}); err != nil {
-				log.Error(err, "error sending ice candidate")
-			}
+			})
 		}
 		p.OnICEConnectionStateChange = func(s webrtc.ICEConnectionState) {
-			if s == webrtc.ICEConnectionStateFailed || s == webrtc.ICEConnectionStateClosed {
-				log.Info("peer ice failed/closed, closing peer and websocket")
-				p.Close()
-				conn.Close()
+			switch s {
+			case webrtc.ICEConnectionStateConnected:
+				p.mu.Lock()
+				p.iceRestarts = 0
+				p.iceRestarting = [2]bool{}
+				p.mu.Unlock()
+			case webrtc.ICEConnectionStateDisconnected:
+				p.onICEDisconnected()
+			case webrtc.ICEConnectionStateFailed:
+				// the sfu has closed the transport, it can't be restarted any more
+				log.Info("peer ice failed, closing peer and websocket", "id", p.ID())
+				p.disconnect(DisconnectReasonICEFailed)
+			case webrtc.ICEConnectionStateClosed:
+				log.Info("peer ice closed, closing peer and websocket")
+				p.Leave()
+				p.closeConn()
 			}
 		}
 
+		err = p.Join(join.SID, join.UID, sfu.JoinConfig{NoAutoSubscribe: join.NoAutoSubscribe})
+		if err != nil {
+			replyError(err)
+			break
+		}
+
+		answer, err := p.answer(ctx, join.Offer)
+		if err != nil {
+			replyError(err)
+			break
+		}
+
 		s, _ := p.c.GetSession(join.SID)
 		session := s.(*Session)
 
 		listen := make(chan Broadcast, 32)
-		session.BroadcastAddListener(p.ID(), listen)
+		disconnect := make(chan DisconnectReason, 1)
+		session.BroadcastAddListener(p.ID(), listen, disconnect)
 
 		p.sid = join.SID
+		p.uid = join.UID
+		p.session = session
+		p.left = make(chan struct{})
+		p.c.events().Emit(PeerJoined{SessionID: join.SID, PeerID: p.ID(), UID: join.UID})
+
+		if pub := p.Publisher(); pub != nil {
+			pub.OnPublisherTrack(func(track sfu.PublisherTrack) {
+				published := TrackPublished{
+					SessionID: join.SID,
+					PeerID:    p.ID(),
+					UID:       join.UID,
+					TrackID:   track.Track.ID(),
+					StreamID:  track.Track.StreamID(),
+					Kind:      track.Track.Kind().String(),
+				}
+				p.mu.Lock()
+				p.published = append(p.published, published)
+				p.mu.Unlock()
+				p.c.events().Emit(published)
 
-		stop := conn.DisconnectNotify()
+				if track.Track.Kind() == webrtc.RTPCodecTypeAudio {
+					session.addSpeakerStream(p.ID(), track)
+				}
+			})
+		}
+
+		p.armTokenExpiry(p.tokenExpires)
+
+		left := p.left
 		go func() {
 			log.Info("peer starting broadcast listener")
 			for {
 				select {
+				case reason := <-disconnect:
+					p.disconnect(reason)
+					return
 				case msg := <-listen:
 					log.Info("peer got broadcast", "id", p.ID(), "msg", msg)
-					conn.Notify(ctx, msg.method, msg.params)
-				case <-stop:
-					session.BroadcastRemoveListener(p.ID())
-					session.UpdatePresenceMetaForPeer(p.ID(), nil)
+					p.notify(msg.method, msg.params)
+				case <-left:
 					log.Info("peer broadcast listener closed", "id", p.ID())
 					return
 				}
@@ -142,6 +478,14 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 
 		_ = conn.Reply(ctx, req.ID, answer)
 
+		if p.resume != nil && p.resume.grace > 0 {
+			p.resumeToken = uuid.New()
+			p.notify("resumable", Resumable{
+				Token:         p.resumeToken,
+				GracePeriodMS: p.resume.grace.Milliseconds(),
+			})
+		}
+
 	case "offer":
 		var negotiation Negotiation
 		err := json.Unmarshal(*req.Params, &negotiation)
@@ -151,7 +495,13 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			break
 		}
 
-		answer, err := p.Answer(negotiation.Desc)
+		if err := admitPublishTracks(negotiation.Desc, p.signal.conf().Limits); err != nil {
+			replyError(err)
+			break
+		}
+		p.iceRestarting[TransportPublisher] = false
+
+		answer, err := p.answer(ctx, negotiation.Desc)
 		if err != nil {
 			replyError(err)
 			break
@@ -171,6 +521,7 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 		if err != nil {
 			replyError(err)
 		}
+		p.iceRestarting[TransportSubscriber] = false
 
 	case "trickle":
 		var trickle Trickle
@@ -199,9 +550,116 @@ func (p *JSONSignal) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonr
 			break
 		}
 
-		s, _ := p.c.GetSession(p.sid)
-		session := s.(*Session)
-		session.UpdatePresenceMetaForPeer(p.ID(), meta)
+		p.session.UpdatePresenceMetaForPeer(p.ID(), meta)
+
+	case "restart_ice":
+		if p.session == nil {
+			replyError(fmt.Errorf("cannot restart ice for peer not in any session"))
+			break
+		}
+		var restart RestartICE
+		err := json.Unmarshal(*req.Params, &restart)
+		if err != nil {
+			log.Error(err, "restart_ice: error parsing target")
+			replyError(err)
+			break
+		}
+
+		if restart.Target != TransportPublisher && restart.Target != TransportSubscriber {
+			replyError(fmt.Errorf("unknown transport target %v", restart.Target))
+			break
+		}
+
+		// Already restarting from our side of the same failure, the client has or will get our offer
+		if p.iceRestarting[restart.Target] {
+			log.V(1).Info("ice restart already in flight", "id", p.ID(), "target", restart.Target)
+			_ = conn.Reply(ctx, req.ID, true)
+			break
+		}
+
+		if !p.iceTransportsOpen() {
+			replyError(fmt.Errorf("transport closed after ice failed"))
+			go p.disconnect(DisconnectReasonICEFailed)
+			break
+		}
+		if !p.iceRestartAllowed() {
+			replyError(fmt.Errorf("ice restart attempts exhausted"))
+			go p.disconnect(DisconnectReasonICEFailed)
+			break
+		}
+		p.iceRestarting[restart.Target] = true
+
+		switch restart.Target {
+		case TransportPublisher:
+			// the client is the offerer, it follows up with an ice restart offer
+		case TransportSubscriber:
+			err = p.restartSubscriberICE()
+		}
+		if err != nil {
+			replyError(err)
+			break
+		}
+		_ = conn.Reply(ctx, req.ID, true)
+
+	case "subscribe", "unsubscribe", "pause", "unpause":
+		if p.session == nil {
+			replyError(fmt.Errorf("cannot %v for peer not in any session", req.Method))
+			break
+		}
+		var subscription Subscription
+		err := json.Unmarshal(*req.Params, &subscription)
+		if err != nil {
+			log.Error(err, "subscription: error parsing streams")
+			replyError(err)
+			break
+		}
+
+		switch req.Method {
+		case "subscribe":
+			err = p.subscribe(subscription.StreamIDs)
+		case "unsubscribe":
+			err = p.unsubscribe(subscription.StreamIDs)
+		case "pause":
+			err = p.pause(subscription, true)
+		case "unpause":
+			err = p.pause(subscription, false)
+		}
+		if err != nil {
+			replyError(err)
+			break
+		}
+		_ = conn.Reply(ctx, req.ID, true)
+
+	case "set_layer":
+		if p.session == nil {
+			replyError(fmt.Errorf("cannot set layer for peer not in any session"))
+			break
+		}
+		var layer SubscriptionLayer
+		err := json.Unmarshal(*req.Params, &layer)
+		if err != nil {
+			log.Error(err, "subscription: error parsing layer")
+			replyError(err)
+			break
+		}
+
+		if err := p.setLayer(layer); err != nil {
+			replyError(err)
+			break
+		}
+		_ = conn.Reply(ctx, req.ID, true)
+
+	case "stats":
+		if p.session == nil {
+			replyError(fmt.Errorf("cannot get stats for peer not in any session"))
+			break
+		}
+		_ = conn.Reply(ctx, req.ID, peerQualityStats(p.sid, p.PeerLocal, p.session.publishedStreamIDs()))
+
+	case "leave":
+		p.leave()
+		_ = conn.Reply(ctx, req.ID, true)
+		p.closeConn()
 
 	case "ping":
 		_ = conn.Reply(ctx, req.ID, "pong")
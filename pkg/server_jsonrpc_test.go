@@ -0,0 +1,430 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/webrtc/v3"
+	"github.com/sourcegraph/jsonrpc2"
+	websocketjsonrpc2 "github.com/sourcegraph/jsonrpc2/websocket"
+)
+
+const testTimeout = 10 * time.Second
+
+// testSignal serves the signaling websocket of a local coordinator, se changes the sfu's
+// setting engine, e.g. to put it on a vnet
+func testSignal(t *testing.T, conf RootConfig, se func(*webrtc.SettingEngine), sinks ...EventSink) (*Signal, *httptest.Server) {
+	t.Helper()
+	if conf.Signal.HTTPAddr == "" {
+		conf.Signal.HTTPAddr = "127.0.0.1:7000"
+	}
+	c, err := newCoordinatorLocal(conf, sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if se != nil {
+		se(&c.(*localCoordinator).w.Setting)
+	}
+
+	s, _ := NewSignal(c, conf.Signal)
+	srv := httptest.NewServer(s.websocketHandler())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+// testPeer is a signaling client with a publisher and subscriber peer connection
+type testPeer struct {
+	t    *testing.T
+	conn *jsonrpc2.Conn
+	pub  *webrtc.PeerConnection
+	sub  *webrtc.PeerConnection
+
+	mu         sync.Mutex
+	candidates [2][]webrtc.ICECandidateInit
+	notes      map[string][]json.RawMessage
+}
+
+// dialTestPeer connects to the session websocket, se changes the peer connections' setting engine
+func dialTestPeer(t *testing.T, srv *httptest.Server, sid string, se func(*webrtc.SettingEngine)) *testPeer {
+	t.Helper()
+	return dialTestPeerToken(t, srv, sid, "", se)
+}
+
+// dialTestPeerToken connects to the session websocket with an access token
+func dialTestPeerToken(t *testing.T, srv *httptest.Server, sid, token string, se func(*webrtc.SettingEngine)) *testPeer {
+	t.Helper()
+	p := &testPeer{t: t, notes: make(map[string][]json.RawMessage)}
+
+	var settings webrtc.SettingEngine
+	if se != nil {
+		se(&settings)
+	}
+	var m webrtc.MediaEngine
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&m), webrtc.WithSettingEngine(settings))
+	var err error
+	if p.pub, err = api.NewPeerConnection(webrtc.Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+	if p.sub, err = api.NewPeerConnection(webrtc.Configuration{}); err != nil {
+		t.Fatal(err)
+	}
+	for target, pc := range []*webrtc.PeerConnection{p.pub, p.sub} {
+		target := target
+		pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+			if c != nil {
+				_ = p.conn.Notify(context.Background(), "trickle", Trickle{Target: target, Candidate: c.ToJSON()})
+			}
+		})
+	}
+	if _, err := p.pub.CreateDataChannel(sfu.APIChannelLabel, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p.dial(srv, sid, token)
+	t.Cleanup(func() {
+		p.conn.Close()
+		p.pub.Close()
+		p.sub.Close()
+	})
+	return p
+}
+
+// dial opens a new websocket for the peer, replacing the one it had
+func (p *testPeer) dial(srv *httptest.Server, sid, token string) {
+	p.t.Helper()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/session/" + sid
+	if token != "" {
+		u += "?access_token=" + token
+	}
+	ws, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.conn = jsonrpc2.NewConn(context.Background(), websocketjsonrpc2.NewObjectStream(ws), p)
+}
+
+// Handle answers subscriber offers, adds trickled candidates, restarts the publisher on
+// request and records every notification
+func (p *testPeer) Handle(ctx context.Context, conn *jsonrpc2.Conn, req *jsonrpc2.Request) {
+	var params json.RawMessage
+	if req.Params != nil {
+		params = *req.Params
+	}
+	p.mu.Lock()
+	p.notes[req.Method] = append(p.notes[req.Method], params)
+	p.mu.Unlock()
+
+	switch req.Method {
+	case "offer":
+		var offer webrtc.SessionDescription
+		if err := json.Unmarshal(params, &offer); err != nil {
+			p.t.Error(err)
+			return
+		}
+		if err := p.sub.SetRemoteDescription(offer); err != nil {
+			p.t.Error(err)
+			return
+		}
+		p.flushCandidates(TransportSubscriber)
+		answer, err := p.sub.CreateAnswer(nil)
+		if err != nil {
+			p.t.Error(err)
+			return
+		}
+		if err := p.sub.SetLocalDescription(answer); err != nil {
+			p.t.Error(err)
+			return
+		}
+		_ = conn.Notify(ctx, "answer", Negotiation{Desc: answer})
+
+	case "trickle":
+		var trickle Trickle
+		if err := json.Unmarshal(params, &trickle); err != nil {
+			p.t.Error(err)
+			return
+		}
+		p.mu.Lock()
+		p.candidates[trickle.Target] = append(p.candidates[trickle.Target], trickle.Candidate)
+		p.mu.Unlock()
+		p.flushCandidates(trickle.Target)
+
+	case "restart_ice":
+		var restart RestartICE
+		if err := json.Unmarshal(params, &restart); err != nil {
+			p.t.Error(err)
+			return
+		}
+		if restart.Target == TransportPublisher {
+			go p.negotiate(&webrtc.OfferOptions{ICERestart: true})
+		}
+	}
+}
+
+// flushCandidates adds the candidates trickled for target once it has a remote description
+func (p *testPeer) flushCandidates(target int) {
+	pc := []*webrtc.PeerConnection{p.pub, p.sub}[target]
+	if pc.RemoteDescription() == nil {
+		return
+	}
+	p.mu.Lock()
+	candidates := p.candidates[target]
+	p.candidates[target] = nil
+	p.mu.Unlock()
+	for _, c := range candidates {
+		if err := pc.AddICECandidate(c); err != nil {
+			p.t.Error(err)
+		}
+	}
+}
+
+// join sends the publisher offer in a join and applies the answer
+func (p *testPeer) join(sid, uid string) error {
+	return p.joinWith(Join{SID: sid, UID: uid})
+}
+
+// joinWith sends j with the publisher offer and applies the answer
+func (p *testPeer) joinWith(j Join) error {
+	offer, err := p.pub.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := p.pub.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	j.Offer = offer
+	var answer webrtc.SessionDescription
+	if err := p.conn.Call(context.Background(), "join", j, &answer); err != nil {
+		return err
+	}
+	if err := p.pub.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+	p.flushCandidates(TransportPublisher)
+	return nil
+}
+
+// negotiate sends a publisher offer and applies the answer
+func (p *testPeer) negotiate(options *webrtc.OfferOptions) {
+	offer, err := p.pub.CreateOffer(options)
+	if err != nil {
+		p.t.Error(err)
+		return
+	}
+	if err := p.pub.SetLocalDescription(offer); err != nil {
+		p.t.Error(err)
+		return
+	}
+	var answer webrtc.SessionDescription
+	if err := p.conn.Call(context.Background(), "offer", Negotiation{Desc: offer}, &answer); err != nil {
+		p.t.Error(err)
+		return
+	}
+	if err := p.pub.SetRemoteDescription(answer); err != nil {
+		p.t.Error(err)
+	}
+}
+
+// notified returns the params of the notifications received for method
+func (p *testPeer) notified(method string) []json.RawMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]json.RawMessage(nil), p.notes[method]...)
+}
+
+// waitFor polls until cond is true, failing the test on timeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testNetwork is a vnet between the sfu and clients that can drop every packet
+type testNetwork struct {
+	router  *vnet.Router
+	blocked int32
+	next    int
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	t.Helper()
+	router, err := vnet.NewRouter(&vnet.RouterConfig{CIDR: "10.0.0.0/24", LoggerFactory: logging.NewDefaultLoggerFactory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &testNetwork{router: router, next: 1}
+	router.AddChunkFilter(func(vnet.Chunk) bool {
+		return atomic.LoadInt32(&n.blocked) == 0
+	})
+	t.Cleanup(func() { _ = router.Stop() })
+	return n
+}
+
+// settings puts a setting engine on a new host of the network with short ice timeouts,
+// it must be called for every host before start
+func (n *testNetwork) settings(t *testing.T) func(*webrtc.SettingEngine) {
+	n.next++
+	vn := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{fmt.Sprintf("10.0.0.%v", n.next)}})
+	if err := n.router.AddNet(vn); err != nil {
+		t.Fatal(err)
+	}
+	return func(se *webrtc.SettingEngine) {
+		se.SetVNet(vn)
+		se.SetICETimeouts(500*time.Millisecond, 5*time.Second, 100*time.Millisecond)
+	}
+}
+
+func (n *testNetwork) start(t *testing.T) {
+	if err := n.router.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (n *testNetwork) block(blocked bool) {
+	var v int32
+	if blocked {
+		v = 1
+	}
+	atomic.StoreInt32(&n.blocked, v)
+}
+
+// testSessionPeer is the sfu peer of the only peer in sid
+func testSessionPeer(t *testing.T, s *Signal, sid string) sfu.Peer {
+	t.Helper()
+	var peer sfu.Peer
+	waitFor(t, "peer to join "+sid, func() bool {
+		session := localSession(s.c, sid)
+		if session == nil || len(session.Peers()) != 1 {
+			return false
+		}
+		peer = session.Peers()[0]
+		return true
+	})
+	return peer
+}
+
+func TestICERestart(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		attempts       int
+		wantDisconnect bool
+	}{
+		{"restarts when disconnected", 2, false},
+		{"disconnects without attempts", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			network := newTestNetwork(t)
+			serverSettings, clientSettings := network.settings(t), network.settings(t)
+			network.start(t)
+
+			var conf RootConfig
+			conf.Signal.ICERestartAttempts = tc.attempts
+			s, srv := testSignal(t, conf, serverSettings)
+			p := dialTestPeer(t, srv, "ice", clientSettings)
+
+			connected := make(chan struct{}, 8)
+			p.pub.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+				if state == webrtc.ICEConnectionStateConnected {
+					connected <- struct{}{}
+				}
+			})
+			if err := p.join("ice", "a"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-connected:
+			case <-time.After(testTimeout):
+				t.Fatal("publisher didn't connect")
+			}
+			pc := testSessionPeer(t, s, "ice").Publisher().PeerConnection()
+			remoteSDP := pc.RemoteDescription().SDP
+
+			network.block(true)
+			if tc.wantDisconnect {
+				waitFor(t, "disconnect", func() bool { return len(p.notified("disconnect")) > 0 })
+				var d Disconnect
+				if err := json.Unmarshal(p.notified("disconnect")[0], &d); err != nil || d.Reason != DisconnectReasonICEFailed {
+					t.Errorf("disconnect = %+v, %v, want %v", d, err, DisconnectReasonICEFailed)
+				}
+				return
+			}
+
+			waitFor(t, "restart_ice", func() bool { return len(p.notified("restart_ice")) > 0 })
+			waitFor(t, "ice restart offer", func() bool { return pc.RemoteDescription().SDP != remoteSDP })
+			network.block(false)
+
+			select {
+			case <-connected:
+			case <-time.After(testTimeout):
+				t.Fatal("publisher didn't reconnect after the ice restart")
+			}
+			if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+				t.Error("sfu closed the publisher")
+			}
+			if got := p.notified("disconnect"); len(got) > 0 {
+				t.Errorf("disconnected after restart: %s", got[0])
+			}
+			if peers := localSession(s.c, "ice").Peers(); len(peers) != 1 {
+				t.Errorf("%v peers in the session after restart, want 1", len(peers))
+			}
+		})
+	}
+}
+
+func TestDisconnectAll(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		close  func(t *testing.T, s *Signal)
+		reason DisconnectReason
+	}{
+		{"drain", func(t *testing.T, s *Signal) {
+			s.Drain()
+		}, DisconnectReasonNodeDraining},
+		{"close session", func(t *testing.T, s *Signal) {
+			req := httptest.NewRequest(http.MethodDelete, "/admin/sessions/all", nil)
+			req.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			s.adminHandler().ServeHTTP(w, req)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("close session = %v %v, want %v", w.Code, w.Body.String(), http.StatusNoContent)
+			}
+		}, DisconnectReasonSessionClosed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var conf RootConfig
+			conf.Signal.Admin.Token = "admin"
+			s, srv := testSignal(t, conf, nil)
+			p := dialTestPeer(t, srv, "all", nil)
+			if err := p.join("all", "a"); err != nil {
+				t.Fatal(err)
+			}
+			testSessionPeer(t, s, "all")
+
+			tc.close(t, s)
+			waitFor(t, "disconnect", func() bool { return len(p.notified("disconnect")) > 0 })
+			var d Disconnect
+			if err := json.Unmarshal(p.notified("disconnect")[0], &d); err != nil || d.Reason != tc.reason {
+				t.Errorf("disconnect = %+v, %v, want %v", d, err, tc.reason)
+			}
+		})
+	}
+}
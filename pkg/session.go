@@ -3,8 +3,10 @@ package cluster
 import (
 	"os"
 	"sync"
+	"time"
 
 	"github.com/getlantern/deepcopy"
+	"github.com/pion/ion-sfu/pkg/buffer"
 	sfu "github.com/pion/ion-sfu/pkg/sfu"
 )
 
@@ -13,24 +15,59 @@ type Broadcast struct {
 	params interface{}
 }
 
+// broadcastListener is a peer's connection in the session. Broadcasts are dropped while its
+// channel is full, disconnects have their own channel so they never are.
+type broadcastListener struct {
+	broadcasts chan<- Broadcast
+	disconnect chan<- DisconnectReason
+}
+
 type Session struct {
 	mu               sync.Mutex
 	presence         map[string]interface{}
 	presenceRevision uint64
 
-	broadcastListeners map[string]chan<- Broadcast
+	broadcastListeners map[string]broadcastListener
+
+	audioObserver  *sfu.AudioObserver
+	speakers       *speakerPeers
+	bufferFactory  *buffer.Factory
+	activeSpeakers []Speaker
+	done           chan struct{}
+	sink           EventSink
 
 	sfu.SessionLocal
 }
 
-func NewSession(id string, dcs []*sfu.Datachannel, cfg sfu.WebRTCTransportConfig) Session {
-	return Session{
+func NewSession(id string, dcs []*sfu.Datachannel, cfg sfu.WebRTCTransportConfig, sink EventSink) *Session {
+	interval := cfg.Router.AudioLevelInterval
+	if interval <= 0 {
+		interval = 1000
+	}
+
+	s := &Session{
 		sync.Mutex{},
 		make(map[string]interface{}),
 		0,
-		make(map[string]chan<- Broadcast),
+		make(map[string]broadcastListener),
+		sfu.NewAudioObserver(cfg.Router.AudioLevelThreshold, interval, cfg.Router.AudioLevelFilter),
+		newSpeakerPeers(cfg.Router.AudioLevelThreshold, interval, cfg.Router.AudioLevelFilter),
+		cfg.BufferFactory,
+		nil,
+		make(chan struct{}),
+		sink,
 		*sfu.NewSession(id, dcs, cfg).(*sfu.SessionLocal),
 	}
+	go s.speakerLoop(time.Duration(interval) * time.Millisecond)
+	return s
+}
+
+// OnClose sets the handler called when the last peer leaves the session
+func (s *Session) OnClose(f func()) {
+	s.SessionLocal.OnClose(func() {
+		close(s.done)
+		f()
+	})
 }
 
 func (s *Session) UpdatePresenceMetaForPeer(peerID string, meta interface{}) {
@@ -55,17 +92,27 @@ func (s *Session) UpdatePresenceMetaForPeer(peerID string, meta interface{}) {
 			SystemInfo: map[string]string{
 				"pod": os.Getenv("POD_NAME"),
 			},
+			Speakers: s.activeSpeakers,
 		},
 	}
 
 	s.Broadcast(msg)
+
+	s.sink.Emit(PresenceChanged{
+		SessionID: s.ID(),
+		PeerID:    peerID,
+		Revision:  s.presenceRevision,
+		Meta:      currentPresence,
+	})
 }
 
-func (s *Session) BroadcastAddListener(peerID string, ch chan<- Broadcast) {
+// BroadcastAddListener adds a peer's broadcast channel, and its disconnect channel which
+// must have room for one reason
+func (s *Session) BroadcastAddListener(peerID string, ch chan<- Broadcast, disconnect chan<- DisconnectReason) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.broadcastListeners[peerID] = ch
+	s.broadcastListeners[peerID] = broadcastListener{broadcasts: ch, disconnect: disconnect}
 }
 
 func (s *Session) BroadcastRemoveListener(peerID string) {
@@ -74,13 +121,46 @@ func (s *Session) BroadcastRemoveListener(peerID string) {
 	delete(s.broadcastListeners, peerID)
 }
 
+// Broadcast sends msg to every listener, s.mu must be held. A listener that is behind misses
+// msg, presence and speakers carry the full state so the next one catches it up.
 func (s *Session) Broadcast(msg Broadcast) {
-	for id, ch := range s.broadcastListeners {
+	for id, l := range s.broadcastListeners {
 		select {
-		case ch <- msg:
+		case l.broadcasts <- msg:
 			log.V(4).Info("wrote broadcast", "msg", msg)
 		default:
-			log.Error(nil, "couldn't write broadcast to channel, removing", "id", id)
+			log.Error(nil, "broadcast channel full, dropping message for peer", "id", id, "method", msg.method)
 		}
 	}
 }
+
+// DisconnectPeer asks the connection for peerID to disconnect with the given reason
+func (s *Session) DisconnectPeer(peerID string, reason DisconnectReason) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.broadcastListeners[peerID]
+	if !ok {
+		return false
+	}
+	l.sendDisconnect(reason)
+	return true
+}
+
+// DisconnectAll asks every connection in the session to disconnect with the given reason
+func (s *Session) DisconnectAll(reason DisconnectReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.broadcastListeners {
+		l.sendDisconnect(reason)
+	}
+}
+
+// sendDisconnect queues reason, if a disconnect is already queued the peer is leaving anyway
+func (l broadcastListener) sendDisconnect(reason DisconnectReason) {
+	select {
+	case l.disconnect <- reason:
+	default:
+	}
+}
@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/ion-sfu/pkg/sfu"
+)
+
+// Speaker is an active speaker in a session, loudest first in Speakers
+type Speaker struct {
+	PeerID   string `json:"peer_id"`
+	StreamID string `json:"stream_id"`
+	// Level is the stream's average audio level over the last interval in -dBov, 0 is the
+	// loudest and 127 silence
+	Level uint8 `json:"level"`
+}
+
+// Speakers message broadcast to every peer when the active speakers in a session change
+type Speakers struct {
+	Speakers []Speaker `json:"speakers"`
+}
+
+type speakerStream struct {
+	peerID string
+	sum    int
+	total  int
+}
+
+// speakerPeers observes the audio levels of the streams published in a session, the same
+// way as the sfu's AudioObserver, and keeps the peer and level of each stream
+type speakerPeers struct {
+	mu        sync.Mutex
+	streams   map[string]*speakerStream
+	threshold uint8
+	expected  int
+	previous  []string
+}
+
+// newSpeakerPeers takes the sfu router's audio level threshold, interval and filter
+func newSpeakerPeers(threshold uint8, interval, filter int) *speakerPeers {
+	if threshold > 127 {
+		threshold = 127
+	}
+	if filter < 0 {
+		filter = 0
+	}
+	if filter > 100 {
+		filter = 100
+	}
+	return &speakerPeers{
+		streams:   make(map[string]*speakerStream),
+		threshold: threshold,
+		expected:  interval * filter / 2000,
+	}
+}
+
+func (sp *speakerPeers) addStream(streamID, peerID string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.streams[streamID] = &speakerStream{peerID: peerID}
+}
+
+func (sp *speakerPeers) removePeer(peerID string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for id, stream := range sp.streams {
+		if stream.peerID == peerID {
+			delete(sp.streams, id)
+		}
+	}
+}
+
+// observe records the level of an audio packet, levels above the threshold are too quiet to count
+func (sp *speakerPeers) observe(streamID string, level uint8) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if stream, ok := sp.streams[streamID]; ok && level <= sp.threshold {
+		stream.sum += int(level)
+		stream.total++
+	}
+}
+
+// calc returns the speakers of the last interval, loudest first, and starts the next one. It
+// returns nil when the order of the speakers hasn't changed.
+func (sp *speakerPeers) calc() []Speaker {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	streamIDs := make([]string, 0, len(sp.streams))
+	for id := range sp.streams {
+		streamIDs = append(streamIDs, id)
+	}
+	// Speaking in more packets comes first, then the louder, as in the sfu's observer
+	sort.Slice(streamIDs, func(i, j int) bool {
+		si, sj := sp.streams[streamIDs[i]], sp.streams[streamIDs[j]]
+		switch {
+		case si.total != sj.total:
+			return si.total > sj.total
+		case si.sum != sj.sum:
+			return si.sum < sj.sum
+		default:
+			return streamIDs[i] < streamIDs[j]
+		}
+	})
+
+	speakers := make([]Speaker, 0, len(streamIDs))
+	ids := make([]string, 0, len(streamIDs))
+	for _, id := range streamIDs {
+		stream := sp.streams[id]
+		if stream.total >= sp.expected {
+			level := uint8(127)
+			if stream.total > 0 {
+				level = uint8(stream.sum / stream.total)
+			}
+			speakers = append(speakers, Speaker{PeerID: stream.peerID, StreamID: id, Level: level})
+			ids = append(ids, id)
+		}
+		stream.sum = 0
+		stream.total = 0
+	}
+
+	if reflect.DeepEqual(ids, sp.previous) {
+		return nil
+	}
+	sp.previous = ids
+	return speakers
+}
+
+// AudioObserver is the observer the sfu router adds and removes this session's audio streams
+// on. Nothing reads it, addSpeakerStream takes the audio levels for speakerLoop, and being the
+// session's own it keeps the embedded SessionLocal's audioLevels loop quiet.
+func (s *Session) AudioObserver() *sfu.AudioObserver {
+	return s.audioObserver
+}
+
+// addSpeakerStream records the peer publishing an audio stream and takes over its audio level
+// callback from the sfu router, for the speakers notification. The buffer only holds one
+// callback and the sfu's observer can't be fed from here, so the session observes the levels.
+func (s *Session) addSpeakerStream(peerID string, track sfu.PublisherTrack) {
+	streamID := track.Track.StreamID()
+	s.speakers.addStream(streamID, peerID)
+
+	if s.bufferFactory == nil {
+		return
+	}
+	buff := s.bufferFactory.GetBuffer(uint32(track.Track.SSRC()))
+	if buff == nil {
+		return
+	}
+	// The buffer calls the callback with its lock held
+	buff.Lock()
+	buff.OnAudioLevel(func(level uint8) {
+		s.speakers.observe(streamID, level)
+	})
+	buff.Unlock()
+}
+
+func (s *Session) speakerLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			speakers := s.speakers.calc()
+			if speakers == nil {
+				continue
+			}
+			streamIDs := make([]string, 0, len(speakers))
+			for _, speaker := range speakers {
+				streamIDs = append(streamIDs, speaker.StreamID)
+			}
+
+			s.mu.Lock()
+			s.activeSpeakers = speakers
+			s.Broadcast(Broadcast{
+				method: "speakers",
+				params: Speakers{Speakers: speakers},
+			})
+			s.mu.Unlock()
+
+			// The sfu's datachannel message, sent from here since this loop observes the levels
+			msg, err := json.Marshal(&sfu.ChannelAPIMessage{
+				Method: sfu.AudioLevelsMethod,
+				Params: streamIDs,
+			})
+			if err != nil {
+				log.Error(err, "error marshaling audio levels")
+				continue
+			}
+			for _, dc := range s.GetDataChannels("", sfu.APIChannelLabel) {
+				if err := dc.SendText(string(msg)); err != nil {
+					log.Error(err, "error sending audio levels")
+				}
+			}
+		}
+	}
+}
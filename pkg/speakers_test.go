@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpeakerPeers(t *testing.T) {
+	type level struct {
+		streamID string
+		level    uint8
+	}
+
+	tests := []struct {
+		name   string
+		filter int
+		levels []level
+		want   []Speaker
+	}{
+		{"no speakers", 100, nil, []Speaker{}},
+		{"more packets first", 0, []level{
+			{"a-audio", 10}, {"b-music", 40}, {"b-music", 50},
+		}, []Speaker{
+			{PeerID: "b", StreamID: "b-music", Level: 45},
+			{PeerID: "a", StreamID: "a-audio", Level: 10},
+			{PeerID: "b", StreamID: "b-audio", Level: 127},
+		}},
+		{"louder first", 0, []level{
+			{"b-music", 40}, {"a-audio", 20}, {"b-audio", 30},
+		}, []Speaker{
+			{PeerID: "a", StreamID: "a-audio", Level: 20},
+			{PeerID: "b", StreamID: "b-audio", Level: 30},
+			{PeerID: "b", StreamID: "b-music", Level: 40},
+		}},
+		{"quieter than the threshold", 0, []level{
+			{"a-audio", 90}, {"b-audio", 70},
+		}, []Speaker{
+			{PeerID: "b", StreamID: "b-audio", Level: 70},
+			{PeerID: "a", StreamID: "a-audio", Level: 127},
+			{PeerID: "b", StreamID: "b-music", Level: 127},
+		}},
+		{"filtered", 20, []level{
+			{"a-audio", 10}, {"b-audio", 20}, {"b-audio", 30}, {"c-audio", 10},
+		}, []Speaker{
+			{PeerID: "b", StreamID: "b-audio", Level: 25},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// filter 20 expects 2 packets in a 200ms interval
+			sp := newSpeakerPeers(80, 200, tt.filter)
+			sp.addStream("a-audio", "a")
+			sp.addStream("b-audio", "b")
+			sp.addStream("b-music", "b")
+			sp.addStream("c-audio", "c")
+			sp.removePeer("c")
+
+			for _, l := range tt.levels {
+				sp.observe(l.streamID, l.level)
+			}
+			if got := sp.calc(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpeakerPeersUnchanged(t *testing.T) {
+	sp := newSpeakerPeers(127, 1000, 0)
+	sp.addStream("a-audio", "a")
+	sp.addStream("b-audio", "b")
+
+	sp.observe("a-audio", 10)
+	if got := sp.calc(); len(got) != 2 {
+		t.Fatalf("calc() = %v, want 2 speakers", got)
+	}
+	sp.observe("a-audio", 60)
+	if got := sp.calc(); got != nil {
+		t.Errorf("calc() with the same order = %v, want nil", got)
+	}
+	sp.observe("b-audio", 60)
+	if got := sp.calc(); len(got) != 2 || got[0].StreamID != "b-audio" || got[0].Level != 60 {
+		t.Errorf("calc() = %v, want b-audio first at 60", got)
+	}
+}
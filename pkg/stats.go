@@ -0,0 +1,215 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/webrtc/v3"
+)
+
+// PeerStats connection quality report for a peer, returned by the stats method and admin api
+type PeerStats struct {
+	SID        string          `json:"sid"`
+	UID        string          `json:"uid"`
+	Publisher  *TransportStats `json:"publisher,omitempty"`
+	Subscriber *TransportStats `json:"subscriber,omitempty"`
+}
+
+// TransportStats quality of one of the peer's peer connections
+type TransportStats struct {
+	// RTT of the selected candidate pair in seconds
+	RTT float64 `json:"rtt"`
+	// AvailableBitrate estimated for the transport in bits per second
+	AvailableBitrate float64             `json:"available_bitrate"`
+	BytesSent        uint64              `json:"bytes_sent"`
+	BytesReceived    uint64              `json:"bytes_received"`
+	CandidatePair    *CandidatePairStats `json:"candidate_pair,omitempty"`
+	Tracks           []TrackStats        `json:"tracks"`
+}
+
+// CandidatePairStats the ice candidate pair selected for a transport
+type CandidatePairStats struct {
+	State  string         `json:"state"`
+	Local  CandidateStats `json:"local"`
+	Remote CandidateStats `json:"remote"`
+}
+
+// CandidateStats one side of a candidate pair
+type CandidateStats struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	IP       string `json:"ip"`
+	Port     int32  `json:"port"`
+}
+
+// TrackStats quality of a published or subscribed track
+type TrackStats struct {
+	TrackID     string  `json:"track_id"`
+	StreamID    string  `json:"stream_id"`
+	Kind        string  `json:"kind"`
+	Bytes       uint64  `json:"bytes"`
+	Packets     uint32  `json:"packets"`
+	PacketsLost int32   `json:"packets_lost"`
+	Jitter      float64 `json:"jitter"`
+	NACKs       uint32  `json:"nacks,omitempty"`
+	PLIs        uint32  `json:"plis,omitempty"`
+	// Bitrate of published tracks in bits per second, per simulcast layer
+	Bitrate []uint64 `json:"bitrate,omitempty"`
+	// SpatialLayer forwarded to the subscriber
+	SpatialLayer *int `json:"spatial_layer,omitempty"`
+}
+
+// peerQualityStats builds the quality report for peer from the pion stats of its transports
+func peerQualityStats(sid string, peer sfu.Peer, streamIDs []string) *PeerStats {
+	stats := &PeerStats{SID: sid, UID: peer.ID()}
+
+	if pub := peer.Publisher(); pub != nil {
+		report := pub.PeerConnection().GetStats()
+		t := transportStats(report)
+		inbound := inboundBySSRC(report)
+		for _, recv := range pub.GetRouter().GetReceiver() {
+			bitrate := recv.GetBitrate()
+			ts := TrackStats{
+				TrackID:  recv.TrackID(),
+				StreamID: recv.StreamID(),
+				Kind:     recv.Kind().String(),
+				Bitrate:  append([]uint64(nil), bitrate[:]...),
+			}
+			// Simulcast receivers have an ssrc per layer, counts are summed and the worst jitter kept
+			for layer := range ts.Bitrate {
+				if in, ok := inbound[webrtc.SSRC(recv.SSRC(layer))]; ok {
+					ts.Bytes += in.BytesReceived
+					ts.Packets += in.PacketsReceived
+					ts.PacketsLost += in.PacketsLost
+					if in.Jitter > ts.Jitter {
+						ts.Jitter = in.Jitter
+					}
+				}
+			}
+			t.Tracks = append(t.Tracks, ts)
+		}
+		stats.Publisher = t
+	}
+
+	if sub := peer.Subscriber(); sub != nil {
+		report := sub.PeerConnection().GetStats()
+		t := transportStats(report)
+		outbound, remote := outboundBySSRC(report)
+		for _, streamID := range streamIDs {
+			for _, dt := range sub.GetDownTracks(streamID) {
+				ts := TrackStats{
+					TrackID:  dt.ID(),
+					StreamID: dt.StreamID(),
+					Kind:     dt.Kind().String(),
+				}
+				if dt.Kind() == webrtc.RTPCodecTypeVideo {
+					layer := dt.CurrentSpatialLayer()
+					ts.SpatialLayer = &layer
+				}
+				if out, ok := outbound[webrtc.SSRC(dt.SSRC())]; ok {
+					ts.Bytes = out.BytesSent
+					ts.Packets = out.PacketsSent
+					ts.NACKs = out.NACKCount
+					ts.PLIs = out.PLICount
+				}
+				if in, ok := remote[webrtc.SSRC(dt.SSRC())]; ok {
+					ts.PacketsLost = in.PacketsLost
+					ts.Jitter = in.Jitter
+				}
+				t.Tracks = append(t.Tracks, ts)
+			}
+		}
+		stats.Subscriber = t
+	}
+
+	return stats
+}
+
+// transportStats reads the selected candidate pair and transport totals from report
+func transportStats(report webrtc.StatsReport) *TransportStats {
+	t := &TransportStats{Tracks: []TrackStats{}}
+
+	candidates := make(map[string]webrtc.ICECandidateStats)
+	for _, stat := range report {
+		switch stat := stat.(type) {
+		case webrtc.ICECandidateStats:
+			candidates[stat.ID] = stat
+		case webrtc.TransportStats:
+			t.BytesSent += stat.BytesSent
+			t.BytesReceived += stat.BytesReceived
+		}
+	}
+
+	for _, stat := range report {
+		pair, ok := stat.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated {
+			continue
+		}
+		t.RTT = pair.CurrentRoundTripTime
+		t.AvailableBitrate = pair.AvailableOutgoingBitrate
+		if pair.AvailableIncomingBitrate > t.AvailableBitrate {
+			t.AvailableBitrate = pair.AvailableIncomingBitrate
+		}
+		t.CandidatePair = &CandidatePairStats{
+			State:  string(pair.State),
+			Local:  candidateStats(candidates[pair.LocalCandidateID]),
+			Remote: candidateStats(candidates[pair.RemoteCandidateID]),
+		}
+		break
+	}
+
+	return t
+}
+
+func candidateStats(c webrtc.ICECandidateStats) CandidateStats {
+	return CandidateStats{
+		Type:     c.CandidateType.String(),
+		Protocol: c.Protocol,
+		IP:       c.IP,
+		Port:     c.Port,
+	}
+}
+
+func inboundBySSRC(report webrtc.StatsReport) map[webrtc.SSRC]webrtc.InboundRTPStreamStats {
+	inbound := make(map[webrtc.SSRC]webrtc.InboundRTPStreamStats)
+	for _, stat := range report {
+		if in, ok := stat.(webrtc.InboundRTPStreamStats); ok {
+			inbound[in.SSRC] = in
+		}
+	}
+	return inbound
+}
+
+func outboundBySSRC(report webrtc.StatsReport) (map[webrtc.SSRC]webrtc.OutboundRTPStreamStats, map[webrtc.SSRC]webrtc.RemoteInboundRTPStreamStats) {
+	outbound := make(map[webrtc.SSRC]webrtc.OutboundRTPStreamStats)
+	remote := make(map[webrtc.SSRC]webrtc.RemoteInboundRTPStreamStats)
+	for _, stat := range report {
+		switch stat := stat.(type) {
+		case webrtc.OutboundRTPStreamStats:
+			outbound[stat.SSRC] = stat
+		case webrtc.RemoteInboundRTPStreamStats:
+			remote[stat.SSRC] = stat
+		}
+	}
+	return outbound, remote
+}
+
+// peerStatsHandler serves the quality report for any peer in a session on this node
+func peerStatsHandler(c coordinator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if session := localSession(c, vars["sid"]); session != nil {
+			for _, peer := range session.Peers() {
+				if peer.ID() != vars["uid"] {
+					continue
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(peerQualityStats(session.ID(), peer, session.publishedStreamIDs()))
+				return
+			}
+		}
+		http.Error(w, "peer not found on this node", http.StatusNotFound)
+	})
+}
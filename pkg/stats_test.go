@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerStatsHandler(t *testing.T) {
+	var conf RootConfig
+	conf.Signal.Admin.Token = "admin"
+	s, srv := testSignal(t, conf, nil)
+	p := dialTestPeer(t, srv, "stats", nil)
+	if err := p.join("stats", "a"); err != nil {
+		t.Fatal(err)
+	}
+	testSessionPeer(t, s, "stats")
+
+	// Stats are only served on the admin listener
+	resp, err := http.Get(srv.URL + "/admin/sessions/stats/peers/a/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stats on the signal listener = %v, want %v", resp.StatusCode, http.StatusNotFound)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		path  string
+		token string
+		want  int
+	}{
+		{"no token", "/admin/sessions/stats/peers/a/stats", "", http.StatusUnauthorized},
+		{"unknown peer", "/admin/sessions/stats/peers/b/stats", "admin", http.StatusNotFound},
+		{"peer", "/admin/sessions/stats/peers/a/stats", "admin", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		s.adminHandler().ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%v: stats = %v %v, want %v", tc.name, w.Code, w.Body.String(), tc.want)
+			continue
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+		var stats PeerStats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil || stats.SID != "stats" || stats.UID != "a" || stats.Publisher == nil {
+			t.Errorf("%v: stats = %+v, %v, want the publisher of a in stats", tc.name, stats, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	errNoSubscriber   = errors.New("peer has no subscriber transport")
+	errStreamNotFound = errors.New("stream not found in session")
+)
+
+// Subscription selects streams for the subscribe, unsubscribe, pause and unpause methods
+type Subscription struct {
+	StreamIDs []string `json:"stream_ids"`
+	// Kind limits pause / unpause to "audio" or "video" tracks, empty matches both
+	Kind string `json:"kind,omitempty"`
+}
+
+// SubscriptionLayer sets the max simulcast layers forwarded for a stream
+type SubscriptionLayer struct {
+	StreamID string `json:"stream_id"`
+	Spatial  *int32 `json:"spatial,omitempty"`
+	Temporal *int32 `json:"temporal,omitempty"`
+}
+
+// subscribe adds downtracks for every track published under streamIDs and renegotiates. Every
+// stream is looked up first so an unknown one fails the request without subscribing to any.
+func (p *JSONSignal) subscribe(streamIDs []string) error {
+	sub := p.Subscriber()
+	if sub == nil {
+		return errNoSubscriber
+	}
+
+	type publishedTrack struct {
+		router sfu.Router
+		recv   sfu.Receiver
+	}
+	var tracks []publishedTrack
+	for _, streamID := range streamIDs {
+		found := false
+		for _, peer := range p.session.Peers() {
+			if peer.ID() == p.ID() || peer.Publisher() == nil {
+				continue
+			}
+
+			router := peer.Publisher().GetRouter()
+			for _, recv := range router.GetReceiver() {
+				if recv.StreamID() == streamID {
+					found = true
+					tracks = append(tracks, publishedTrack{router, recv})
+				}
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: %v", errStreamNotFound, streamID)
+		}
+	}
+
+	// Downtracks added before a failure are still offered to the client
+	defer sub.Negotiate()
+	for _, track := range tracks {
+		if _, err := track.router.AddDownTrack(sub, track.recv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsubscribe closes the downtracks for streamIDs, closing a downtrack renegotiates
+func (p *JSONSignal) unsubscribe(streamIDs []string) error {
+	sub := p.Subscriber()
+	if sub == nil {
+		return errNoSubscriber
+	}
+
+	for _, streamID := range streamIDs {
+		// Closing a downtrack removes it from the subscriber's slice, range over a copy
+		downTracks := append([]*sfu.DownTrack(nil), sub.GetDownTracks(streamID)...)
+		for _, dt := range downTracks {
+			dt.Close()
+		}
+	}
+	return nil
+}
+
+// pause mutes (or unmutes) forwarding of the downtracks for streamIDs
+func (p *JSONSignal) pause(subscription Subscription, paused bool) error {
+	sub := p.Subscriber()
+	if sub == nil {
+		return errNoSubscriber
+	}
+
+	for _, streamID := range subscription.StreamIDs {
+		for _, dt := range sub.GetDownTracks(streamID) {
+			if subscription.Kind != "" && dt.Kind().String() != subscription.Kind {
+				continue
+			}
+			dt.Mute(paused)
+		}
+	}
+	return nil
+}
+
+// setLayer caps the spatial / temporal layers forwarded for a simulcast stream
+func (p *JSONSignal) setLayer(layer SubscriptionLayer) error {
+	sub := p.Subscriber()
+	if sub == nil {
+		return errNoSubscriber
+	}
+
+	downTracks := sub.GetDownTracks(layer.StreamID)
+	if len(downTracks) == 0 {
+		return fmt.Errorf("%w: %v", errStreamNotFound, layer.StreamID)
+	}
+
+	for _, dt := range downTracks {
+		if dt.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if layer.Spatial != nil {
+			if err := dt.SwitchSpatialLayer(*layer.Spatial, true); err != nil {
+				return err
+			}
+		}
+		if layer.Temporal != nil {
+			dt.SwitchTemporalLayer(*layer.Temporal, true)
+		}
+	}
+	return nil
+}
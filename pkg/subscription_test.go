@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+// publishTestTracks adds an audio and a video track under streamID to the peer's publisher
+// and writes samples to them until the test ends
+func publishTestTracks(t *testing.T, p *testPeer, streamID string) {
+	t.Helper()
+	var tracks []*webrtc.TrackLocalStaticSample
+	for id, codec := range map[string]webrtc.RTPCodecCapability{
+		"audio": {MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1"},
+		"video": {MimeType: webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"},
+	} {
+		track, err := webrtc.NewTrackLocalStaticSample(codec, id, streamID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.pub.AddTrack(track); err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, track)
+	}
+
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, track := range tracks {
+					_ = track.WriteSample(media.Sample{Data: []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x00}, Duration: 20 * time.Millisecond})
+				}
+			}
+		}
+	}()
+}
+
+// testSessionPeerID is the sfu peer uid in sid
+func testSessionPeerID(t *testing.T, s *Signal, sid, uid string) sfu.Peer {
+	t.Helper()
+	var peer sfu.Peer
+	waitFor(t, uid+" to join "+sid, func() bool {
+		session := localSession(s.c, sid)
+		if session == nil {
+			return false
+		}
+		for _, p := range session.Peers() {
+			if p.ID() == uid {
+				peer = p
+				return true
+			}
+		}
+		return false
+	})
+	return peer
+}
+
+func (p *testPeer) call(method string, params interface{}) error {
+	var result interface{}
+	return p.conn.Call(context.Background(), method, params, &result)
+}
+
+func TestSubscription(t *testing.T) {
+	var conf RootConfig
+	conf.SFU.Router.MaxPacketTrack = 500
+	events := NewChannelEventSink(32)
+	s, srv := testSignal(t, conf, nil, events)
+
+	publisher := dialTestPeer(t, srv, "subs", nil)
+	publishTestTracks(t, publisher, "a-stream")
+	if err := publisher.join("subs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	for published := 0; published < 2; {
+		select {
+		case e := <-events.C:
+			if _, ok := e.(TrackPublished); ok {
+				published++
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("timed out waiting for the published tracks")
+		}
+	}
+
+	p := dialTestPeer(t, srv, "subs", nil)
+	if err := p.call("subscribe", Subscription{StreamIDs: []string{"a-stream"}}); err == nil {
+		t.Error("subscribe before joining succeeded")
+	}
+	if err := p.joinWith(Join{SID: "subs", UID: "b", NoAutoSubscribe: true}); err != nil {
+		t.Fatal(err)
+	}
+	sub := testSessionPeerID(t, s, "subs", "b").Subscriber()
+	downTracks := func() []*sfu.DownTrack { return sub.GetDownTracks("a-stream") }
+	if got := downTracks(); len(got) != 0 {
+		t.Fatalf("%v downtracks without auto subscribe, want 0", len(got))
+	}
+
+	if err := p.call("subscribe", Subscription{StreamIDs: []string{"a-stream", "missing"}}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("subscribe to a missing stream = %v, want stream not found", err)
+	}
+	if got := downTracks(); len(got) != 0 {
+		t.Fatalf("%v downtracks after a failed subscribe, want 0", len(got))
+	}
+
+	offers := len(p.notified("offer"))
+	if err := p.call("subscribe", Subscription{StreamIDs: []string{"a-stream"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := downTracks(); len(got) != 2 {
+		t.Fatalf("%v downtracks after subscribe, want 2", len(got))
+	}
+	waitFor(t, "subscribe offer", func() bool { return len(p.notified("offer")) > offers })
+
+	enabled := func() map[string]bool {
+		kinds := make(map[string]bool)
+		for _, dt := range downTracks() {
+			kinds[dt.Kind().String()] = dt.Enabled()
+		}
+		return kinds
+	}
+	// Downtracks are enabled once the answer binds them, pausing before that is undone by the bind
+	waitFor(t, "downtracks to bind", func() bool {
+		got := enabled()
+		return got["audio"] && got["video"]
+	})
+	for _, tc := range []struct {
+		method string
+		kind   string
+		want   map[string]bool
+	}{
+		{"pause", "video", map[string]bool{"audio": true, "video": false}},
+		{"pause", "", map[string]bool{"audio": false, "video": false}},
+		{"unpause", "audio", map[string]bool{"audio": true, "video": false}},
+		{"unpause", "", map[string]bool{"audio": true, "video": true}},
+	} {
+		if err := p.call(tc.method, Subscription{StreamIDs: []string{"a-stream"}, Kind: tc.kind}); err != nil {
+			t.Fatalf("%v %q: %v", tc.method, tc.kind, err)
+		}
+		if got := enabled(); got["audio"] != tc.want["audio"] || got["video"] != tc.want["video"] {
+			t.Errorf("after %v %q enabled = %v, want %v", tc.method, tc.kind, got, tc.want)
+		}
+	}
+
+	layer := func(l int32) *int32 { return &l }
+	for _, tc := range []struct {
+		name    string
+		layer   SubscriptionLayer
+		wantErr bool
+	}{
+		{"missing stream", SubscriptionLayer{StreamID: "missing", Temporal: layer(1)}, true},
+		{"spatial without simulcast", SubscriptionLayer{StreamID: "a-stream", Spatial: layer(1)}, true},
+		{"temporal", SubscriptionLayer{StreamID: "a-stream", Temporal: layer(1)}, false},
+	} {
+		if err := p.call("set_layer", tc.layer); (err != nil) != tc.wantErr {
+			t.Errorf("set_layer %v = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	if err := p.call("unsubscribe", Subscription{StreamIDs: []string{"a-stream"}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "downtracks to close", func() bool { return len(downTracks()) == 0 })
+}
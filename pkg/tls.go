@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloadDelay batches the events of a certificate rotation, which usually
+// replaces the cert and key (or a kubernetes secret's symlinks) one after another
+const certReloadDelay = 500 * time.Millisecond
+
+// CertReloader serves certificates loaded from files and reloads them when the files change,
+// a failed reload keeps serving the previous certificates
+type CertReloader struct {
+	pairs []CertConfig
+
+	mu    sync.RWMutex
+	certs []tls.Certificate
+}
+
+// NewCertReloader loads every cert/key pair and watches their directories for changes
+func NewCertReloader(pairs []CertConfig) (*CertReloader, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no certificates configured")
+	}
+
+	r := &CertReloader{pairs: pairs}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, p := range pairs {
+		dirs[filepath.Dir(p.Cert)] = true
+		dirs[filepath.Dir(p.Key)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go r.watch(watcher)
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	certs := make([]tls.Certificate, 0, len(r.pairs))
+	for _, p := range r.pairs {
+		cert, err := tls.LoadX509KeyPair(p.Cert, p.Key)
+		if err != nil {
+			return fmt.Errorf("loading certificate %v: %w", p.Cert, err)
+		}
+		// The leaf is used to pick a certificate by SNI
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("parsing certificate %v: %w", p.Cert, err)
+		}
+		log.Info("loaded certificate", "cert", p.Cert, "names", cert.Leaf.DNSNames, "notAfter", cert.Leaf.NotAfter)
+		certs = append(certs, cert)
+	}
+
+	r.mu.Lock()
+	r.certs = certs
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			reload = time.After(certReloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error(err, "error watching certificates")
+		case <-reload:
+			reload = nil
+			if err := r.reload(); err != nil {
+				log.Error(err, "error reloading certificates, keeping the previous certificates")
+			}
+		}
+	}
+}
+
+// GetCertificate picks the first certificate valid for the client hello's server name,
+// falling back to the first certificate
+func (r *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.certs {
+		if hello.SupportsCertificate(&r.certs[i]) == nil {
+			return &r.certs[i], nil
+		}
+	}
+	return &r.certs[0], nil
+}
+
+// TLSConfig for a listener serving the reloaded certificates
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer delegates to the global tracer provider, which is a no-op until InitTracing enables an exporter
+var tracer = otel.Tracer("github.com/pion/ion-cluster")
+
+// defaultOTLPEndpoint is where spans are sent when tracing.endpoint is empty
+const defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// InitTracing installs an OTLP/HTTP exporter when tracing is enabled, the returned func flushes and stops it.
+func InitTracing(conf TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !conf.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint := conf.Endpoint
+	if endpoint == "" {
+		endpoint = defaultOTLPEndpoint
+	}
+	exporter := newOTLPExporter(endpoint)
+
+	serviceName := conf.ServiceName
+	if serviceName == "" {
+		serviceName = "ion-cluster"
+	}
+	ratio := conf.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+
+	log.Info("tracing enabled", "endpoint", endpoint, "sampleRatio", ratio)
+	return tp.Shutdown, nil
+}
+
+// spanError records err on span and marks it failed
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,236 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpExporter posts spans to an OTLP/HTTP collector as protobuf. otlptracehttp isn't used as
+// its collector protos need a newer grpc than the one etcd's client is pinned to. TracesData is
+// sent, it is the same message on the wire as the collector's ExportTraceServiceRequest.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		stopped:  make(chan struct{}),
+	}
+}
+
+// ExportSpans sends spans in a single request
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	select {
+	case <-e.stopped:
+		return nil
+	default:
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := proto.Marshal(otlpTracesData(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp collector replied %v", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown stops exporting, the batcher flushes before calling it
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() {
+		close(e.stopped)
+		e.client.CloseIdleConnections()
+	})
+	return nil
+}
+
+// otlpTracesData groups spans by resource and instrumentation library
+func otlpTracesData(spans []sdktrace.ReadOnlySpan) *tracepb.TracesData {
+	data := &tracepb.TracesData{}
+	resources := make(map[attribute.Distinct]*tracepb.ResourceSpans)
+	libraries := make(map[attribute.Distinct]map[instrumentation.Library]*tracepb.InstrumentationLibrarySpans)
+
+	for _, span := range spans {
+		res := span.Resource()
+		key := res.Equivalent()
+		rs, ok := resources[key]
+		if !ok {
+			rs = &tracepb.ResourceSpans{
+				Resource:  &resourcepb.Resource{Attributes: otlpAttributes(res.Attributes())},
+				SchemaUrl: res.SchemaURL(),
+			}
+			resources[key] = rs
+			libraries[key] = make(map[instrumentation.Library]*tracepb.InstrumentationLibrarySpans)
+			data.ResourceSpans = append(data.ResourceSpans, rs)
+		}
+
+		lib := span.InstrumentationLibrary()
+		ils, ok := libraries[key][lib]
+		if !ok {
+			ils = &tracepb.InstrumentationLibrarySpans{
+				InstrumentationLibrary: &commonpb.InstrumentationLibrary{Name: lib.Name, Version: lib.Version},
+				SchemaUrl:              lib.SchemaURL,
+			}
+			libraries[key][lib] = ils
+			rs.InstrumentationLibrarySpans = append(rs.InstrumentationLibrarySpans, ils)
+		}
+		ils.Spans = append(ils.Spans, otlpSpan(span))
+	}
+	return data
+}
+
+func otlpSpan(span sdktrace.ReadOnlySpan) *tracepb.Span {
+	sc := span.SpanContext()
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+	s := &tracepb.Span{
+		TraceId:                traceID[:],
+		SpanId:                 spanID[:],
+		TraceState:             sc.TraceState().String(),
+		Name:                   span.Name(),
+		Kind:                   otlpSpanKind(span.SpanKind()),
+		StartTimeUnixNano:      uint64(span.StartTime().UnixNano()),
+		EndTimeUnixNano:        uint64(span.EndTime().UnixNano()),
+		Attributes:             otlpAttributes(span.Attributes()),
+		DroppedAttributesCount: uint32(span.DroppedAttributes()),
+		DroppedEventsCount:     uint32(span.DroppedEvents()),
+		DroppedLinksCount:      uint32(span.DroppedLinks()),
+		Status:                 &tracepb.Status{Message: span.Status().Description},
+	}
+	if parent := span.Parent(); parent.HasSpanID() {
+		parentID := parent.SpanID()
+		s.ParentSpanId = parentID[:]
+	}
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = tracepb.Status_STATUS_CODE_OK
+	case codes.Error:
+		s.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+	}
+
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, &tracepb.Span_Event{
+			TimeUnixNano:           uint64(event.Time.UnixNano()),
+			Name:                   event.Name,
+			Attributes:             otlpAttributes(event.Attributes),
+			DroppedAttributesCount: uint32(event.DroppedAttributeCount),
+		})
+	}
+	for _, link := range span.Links() {
+		traceID, spanID := link.SpanContext.TraceID(), link.SpanContext.SpanID()
+		s.Links = append(s.Links, &tracepb.Span_Link{
+			TraceId:                traceID[:],
+			SpanId:                 spanID[:],
+			TraceState:             link.SpanContext.TraceState().String(),
+			Attributes:             otlpAttributes(link.Attributes),
+			DroppedAttributesCount: uint32(link.DroppedAttributeCount),
+		})
+	}
+	return s
+}
+
+func otlpSpanKind(kind trace.SpanKind) tracepb.Span_SpanKind {
+	switch kind {
+	case trace.SpanKindInternal:
+		return tracepb.Span_SPAN_KIND_INTERNAL
+	case trace.SpanKindServer:
+		return tracepb.Span_SPAN_KIND_SERVER
+	case trace.SpanKindClient:
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case trace.SpanKindProducer:
+		return tracepb.Span_SPAN_KIND_PRODUCER
+	case trace.SpanKindConsumer:
+		return tracepb.Span_SPAN_KIND_CONSUMER
+	default:
+		return tracepb.Span_SPAN_KIND_UNSPECIFIED
+	}
+}
+
+func otlpAttributes(attrs []attribute.KeyValue) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		kvs = append(kvs, &commonpb.KeyValue{Key: string(kv.Key), Value: otlpValue(kv.Value)})
+	}
+	return kvs
+}
+
+func otlpValue(v attribute.Value) *commonpb.AnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v.AsBool()}}
+	case attribute.INT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v.AsInt64()}}
+	case attribute.FLOAT64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v.AsFloat64()}}
+	case attribute.BOOLSLICE:
+		var values []*commonpb.AnyValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, otlpValue(attribute.BoolValue(b)))
+		}
+		return otlpArray(values)
+	case attribute.INT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, otlpValue(attribute.Int64Value(i)))
+		}
+		return otlpArray(values)
+	case attribute.FLOAT64SLICE:
+		var values []*commonpb.AnyValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, otlpValue(attribute.Float64Value(f)))
+		}
+		return otlpArray(values)
+	case attribute.STRINGSLICE:
+		var values []*commonpb.AnyValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, otlpValue(attribute.StringValue(s)))
+		}
+		return otlpArray(values)
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v.Emit()}}
+	}
+}
+
+func otlpArray(values []*commonpb.AnyValue) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan *tracepb.TracesData, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("request %v %v, want a protobuf POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var data tracepb.TracesData
+		if err := proto.Unmarshal(body, &data); err != nil {
+			t.Error(err)
+		}
+		received <- &data
+	}))
+	defer srv.Close()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(newOTLPExporter(srv.URL)))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, span := tp.Tracer("test").Start(ctx, "signal.join", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("session.id", "a"), attribute.Int64Slice("layers", []int64{0, 2})))
+	spanError(span, errors.New("failed"))
+	span.End()
+
+	data := <-received
+	if len(data.ResourceSpans) != 1 || len(data.ResourceSpans[0].InstrumentationLibrarySpans) != 1 {
+		t.Fatalf("resource spans = %v, want one resource and library", data.ResourceSpans)
+	}
+	ils := data.ResourceSpans[0].InstrumentationLibrarySpans[0]
+	if ils.InstrumentationLibrary.Name != "test" || len(ils.Spans) != 1 {
+		t.Fatalf("library spans = %v, want the test library's span", ils)
+	}
+
+	got := ils.Spans[0]
+	parentID := parent.SpanContext().SpanID()
+	switch {
+	case got.Name != "signal.join":
+		t.Errorf("name = %v, want signal.join", got.Name)
+	case got.Kind != tracepb.Span_SPAN_KIND_SERVER:
+		t.Errorf("kind = %v, want server", got.Kind)
+	case string(got.ParentSpanId) != string(parentID[:]):
+		t.Errorf("parent = %x, want %x", got.ParentSpanId, parentID)
+	case got.Status.Code != tracepb.Status_STATUS_CODE_ERROR || got.Status.Message != "failed":
+		t.Errorf("status = %v, want error failed", got.Status)
+	case len(got.Events) != 1 || got.Events[0].Name != "exception":
+		t.Errorf("events = %v, want the recorded error", got.Events)
+	case len(got.Attributes) != 2 || got.Attributes[0].Value.GetStringValue() != "a" ||
+		len(got.Attributes[1].Value.GetArrayValue().GetValues()) != 2:
+		t.Errorf("attributes = %v, want session.id and layers", got.Attributes)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	tp := sdktrace.NewTracerProvider()
+	_, span := tp.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	e := newOTLPExporter(srv.URL)
+	if err := e.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)}); err == nil {
+		t.Error("export to a failing collector succeeded")
+	}
+	_ = e.Shutdown(context.Background())
+	if err := e.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{span.(sdktrace.ReadOnlySpan)}); err != nil {
+		t.Errorf("export after shutdown = %v, want nil", err)
+	}
+}
@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/ion-sfu/pkg/sfu"
+	"github.com/pion/logging"
+	"github.com/pion/turn/v2"
+	"github.com/pion/udp"
+)
+
+// Same relay port range as the ion-sfu turn server
+const (
+	turnMinPort = 32768
+	turnMaxPort = 46883
+)
+
+var turnCredentials = regexp.MustCompile(`(\w+)=(\w+)`)
+
+// InitTurnServer starts the embedded TURN server on sfu.turn.address, relaying from the host
+// of that address as sfu.InitTurnServer does. With a cert and key the tcp listener is tls and
+// a dtls listener is added on the next port, both serving certificates from a CertReloader so
+// rotated certificates are picked up without a restart.
+func InitTurnServer(conf sfu.TurnConfig) (*turn.Server, error) {
+	host, portStr, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("sfu.turn.address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("sfu.turn.address: %w", err)
+	}
+	relayIP := net.ParseIP(host)
+
+	var certs *CertReloader
+	if conf.Cert != "" && conf.Key != "" {
+		if certs, err = NewCertReloader([]CertConfig{{Cert: conf.Cert, Key: conf.Key}}); err != nil {
+			return nil, err
+		}
+	}
+
+	minPort, maxPort := uint16(turnMinPort), uint16(turnMaxPort)
+	if len(conf.PortRange) == 2 {
+		minPort, maxPort = conf.PortRange[0], conf.PortRange[1]
+	}
+	relay := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      "0.0.0.0",
+			MinPort:      minPort,
+			MaxPort:      maxPort,
+		}
+	}
+
+	udpListener, err := net.ListenPacket("udp4", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	tcpListener, err := net.Listen("tcp4", conf.Address)
+	if err != nil {
+		udpListener.Close()
+		return nil, err
+	}
+	listeners := []turn.ListenerConfig{{Listener: tcpListener, RelayAddressGenerator: relay()}}
+
+	if certs != nil {
+		listeners[0].Listener = tls.NewListener(tcpListener, certs.TLSConfig())
+
+		// The udp listener holds the port, dtls can't share it
+		dtlsListener, err := listenTurnDTLS(&net.UDPAddr{IP: relayIP, Port: port + 1}, certs)
+		if err != nil {
+			udpListener.Close()
+			tcpListener.Close()
+			return nil, err
+		}
+		listeners = append(listeners, turn.ListenerConfig{Listener: dtlsListener, RelayAddressGenerator: relay()})
+	}
+
+	return turn.NewServer(turn.ServerConfig{
+		Realm:           conf.Realm,
+		AuthHandler:     turnAuthHandler(conf),
+		ListenerConfigs: listeners,
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udpListener,
+			RelayAddressGenerator: relay(),
+		}},
+	})
+}
+
+// turnDTLSListener accepts dtls connections with the certificate current at the handshake,
+// dtls.Listen fixes its certificates when it is created
+type turnDTLSListener struct {
+	net.Listener
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+func listenTurnDTLS(addr *net.UDPAddr, certs *CertReloader) (net.Listener, error) {
+	parent, err := (&udp.ListenConfig{
+		// Only handshakes open a connection, as dtls.Listen does
+		AcceptFilter: func(packet []byte) bool {
+			pkts, err := recordlayer.UnpackDatagram(packet)
+			if err != nil || len(pkts) < 1 {
+				return false
+			}
+			h := &recordlayer.Header{}
+			if err := h.Unmarshal(pkts[0]); err != nil {
+				return false
+			}
+			return h.ContentType == protocol.ContentTypeHandshake
+		},
+	}).Listen("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &turnDTLSListener{Listener: parent, getCertificate: certs.TLSConfig().GetCertificate}, nil
+}
+
+// Accept returns the next connection that completes a handshake, a failed handshake isn't
+// returned as the turn server stops accepting on the first error
+func (l *turnDTLSListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		cert, err := l.getCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		dtlsConn, err := dtls.Server(conn, &dtls.Config{
+			Certificates:         []tls.Certificate{*cert},
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+			ConnectContextMaker: func() (context.Context, func()) {
+				return context.WithTimeout(context.Background(), 30*time.Second)
+			},
+		})
+		if err != nil {
+			log.V(1).Info("turn dtls handshake failed", "remote", conn.RemoteAddr().String(), "error", err.Error())
+			conn.Close()
+			continue
+		}
+		return dtlsConn, nil
+	}
+}
+
+// turnAuthHandler checks long term credentials from sfu.turn.auth.secret, or the static
+// user=password pairs in sfu.turn.auth.credentials
+func turnAuthHandler(conf sfu.TurnConfig) turn.AuthHandler {
+	if conf.Auth.Secret != "" {
+		logger := logging.NewDefaultLeveledLoggerForScope("lt-creds", logging.LogLevelTrace, os.Stdout)
+		return turn.NewLongTermAuthHandler(conf.Auth.Secret, logger)
+	}
+
+	users := make(map[string][]byte)
+	for _, kv := range turnCredentials.FindAllStringSubmatch(conf.Auth.Credentials, -1) {
+		users[kv[1]] = turn.GenerateAuthKey(kv[1], conf.Realm, kv[2])
+	}
+	if len(users) == 0 {
+		log.Error(nil, "no turn credentials configured, every allocation will be refused")
+	}
+	return func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+		key, ok := users[username]
+		return key, ok
+	}
+}
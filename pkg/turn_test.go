@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// writeTestCert writes a self signed certificate for name to dir as cert.pem and key.pem
+func writeTestCert(t *testing.T, dir, name string) (cert, key string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTurnDTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestCert(t, dir, "a.example.com")
+	certs, err := NewCertReloader([]CertConfig{{Cert: cert, Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := listenTurnDTLS(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	addr := l.Addr().(*net.UDPAddr)
+	served := func() (string, error) {
+		conn, err := dtls.Dial("udp4", addr, &dtls.Config{
+			InsecureSkipVerify:   true,
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		peer, err := x509.ParseCertificate(conn.ConnectionState().PeerCertificates[0])
+		if err != nil {
+			return "", err
+		}
+		return peer.Subject.CommonName, nil
+	}
+
+	// A client the server can't agree a cipher suite with
+	if _, err := dtls.Dial("udp4", addr, &dtls.Config{
+		PSK:             func([]byte) ([]byte, error) { return []byte{1}, nil },
+		PSKIdentityHint: []byte("client"),
+		CipherSuites:    []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8},
+	}); err == nil {
+		t.Fatal("psk handshake with a certificate listener succeeded")
+	}
+
+	if name, err := served(); err != nil || name != "a.example.com" {
+		t.Fatalf("after a failed handshake served %q, %v, want a.example.com", name, err)
+	}
+
+	writeTestCert(t, dir, "b.example.com")
+	waitFor(t, "the rotated certificate", func() bool {
+		name, err := served()
+		return err == nil && name == "b.example.com"
+	})
+}
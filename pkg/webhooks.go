@@ -0,0 +1,322 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// Webhook event names
+const (
+	WebhookSessionStarted = "session_started"
+	WebhookSessionEnded   = "session_ended"
+	WebhookPeerJoined     = "peer_joined"
+	WebhookPeerLeft       = "peer_left"
+	WebhookTrackPublished = "track_published"
+)
+
+const (
+	webhookDefaultQueueSize  = 1024
+	webhookDefaultMaxRetries = 5
+	webhookDefaultTimeout    = 5 * time.Second
+	webhookBackoffBase       = 500 * time.Millisecond
+	webhookBackoffMax        = 30 * time.Second
+
+	// WebhookSignatureHeader carries the hex encoded HMAC-SHA256 of "<timestamp>.<body>", keyed with the webhook secret
+	WebhookSignatureHeader = "X-Ion-Signature"
+	// WebhookTimestampHeader is the unix time in seconds the delivery was signed at, receivers
+	// should refuse old timestamps so captured deliveries can't be replayed
+	WebhookTimestampHeader = "X-Ion-Timestamp"
+)
+
+var (
+	errWebhookSignature = errors.New("webhook signature doesn't match")
+	errWebhookTimestamp = errors.New("webhook timestamp missing or outside the tolerance")
+)
+
+// WebhookEvent is the json body posted to every webhook url
+type WebhookEvent struct {
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	Timestamp int64  `json:"timestamp"`
+	NodeID    string `json:"node_id"`
+	SessionID string `json:"session_id"`
+	PeerID    string `json:"peer_id,omitempty"`
+	UID       string `json:"uid,omitempty"`
+	TrackID   string `json:"track_id,omitempty"`
+	StreamID  string `json:"stream_id,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+}
+
+type webhookEndpoint struct {
+	url string
+	// label is the url's host for metrics, the url itself may carry a token
+	label string
+	queue chan WebhookEvent
+}
+
+// webhookDispatcher delivers events to every configured url. Each url has its own bounded
+// queue and worker so a slow receiver can't hold up the others, failed deliveries are
+// retried with exponential backoff before moving on to the next queued event.
+// Queues live in memory, Close delivers what is queued before the process exits.
+type webhookDispatcher struct {
+	nodeID     string
+	secret     []byte
+	events     map[string]bool
+	maxRetries int
+	backoff    time.Duration
+	client     *http.Client
+	endpoints  []*webhookEndpoint
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	// ctx is cancelled when Close gives up waiting, abandoning deliveries in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWebhookDispatcher(conf WebhookConfig, nodeID string) *webhookDispatcher {
+	queueSize := conf.QueueSize
+	if queueSize <= 0 {
+		queueSize = webhookDefaultQueueSize
+	}
+	maxRetries := conf.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = webhookDefaultMaxRetries
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = webhookDefaultTimeout
+	}
+
+	d := &webhookDispatcher{
+		nodeID:     nodeID,
+		secret:     []byte(conf.Secret),
+		maxRetries: maxRetries,
+		backoff:    webhookBackoffBase,
+		client:     &http.Client{Timeout: timeout},
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	if len(conf.Events) > 0 {
+		d.events = make(map[string]bool)
+		for _, event := range conf.Events {
+			d.events[event] = true
+		}
+	}
+
+	for i, url := range conf.URLs {
+		e := &webhookEndpoint{
+			url:   url,
+			label: webhookLabel(i, url),
+			queue: make(chan WebhookEvent, queueSize),
+		}
+		d.endpoints = append(d.endpoints, e)
+		d.wg.Add(1)
+		go d.run(e)
+	}
+
+	return d
+}
+
+// webhookLabel is the host of the i'th webhook url, or its index when it has none
+func webhookLabel(i int, rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strconv.Itoa(i)
+}
+
+// Emit implements EventSink, sending the lifecycle events as webhooks
+func (d *webhookDispatcher) Emit(event Event) {
+	switch e := event.(type) {
+	case SessionCreated:
+		d.dispatch(WebhookEvent{Event: WebhookSessionStarted, SessionID: e.SessionID})
+	case SessionClosed:
+		d.dispatch(WebhookEvent{Event: WebhookSessionEnded, SessionID: e.SessionID})
+	case PeerJoined:
+		d.dispatch(WebhookEvent{Event: WebhookPeerJoined, SessionID: e.SessionID, PeerID: e.PeerID, UID: e.UID})
+	case PeerLeft:
+		d.dispatch(WebhookEvent{Event: WebhookPeerLeft, SessionID: e.SessionID, PeerID: e.PeerID, UID: e.UID})
+	case TrackPublished:
+		d.dispatch(WebhookEvent{
+			Event:     WebhookTrackPublished,
+			SessionID: e.SessionID,
+			PeerID:    e.PeerID,
+			UID:       e.UID,
+			TrackID:   e.TrackID,
+			StreamID:  e.StreamID,
+			Kind:      e.Kind,
+		})
+	}
+}
+
+// dispatch queues an event for delivery, if a queue is full the event is dropped for that url
+func (d *webhookDispatcher) dispatch(event WebhookEvent) {
+	if d.events != nil && !d.events[event.Event] {
+		return
+	}
+
+	event.ID = uuid.New()
+	event.NodeID = d.nodeID
+	event.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		log.Info("webhooks closed, dropping event", "event", event.Event)
+		prometheusCounterWebhookDeliveries.WithLabelValues(event.Event, "dropped").Inc()
+		return
+	}
+	for _, e := range d.endpoints {
+		select {
+		case e.queue <- event:
+			prometheusGaugeWebhookQueue.WithLabelValues(e.label).Inc()
+		default:
+			log.Error(nil, "webhook queue full, dropping event", "url", e.url, "event", event.Event)
+			prometheusCounterWebhookDeliveries.WithLabelValues(event.Event, "dropped").Inc()
+		}
+	}
+}
+
+// Close stops taking events and waits for the queued ones to be delivered. Once ctx is done
+// deliveries still queued or retrying are dropped.
+func (d *webhookDispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, e := range d.endpoints {
+		close(e.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (d *webhookDispatcher) run(e *webhookEndpoint) {
+	defer d.wg.Done()
+	for event := range e.queue {
+		prometheusGaugeWebhookQueue.WithLabelValues(e.label).Dec()
+		if d.ctx.Err() != nil {
+			prometheusCounterWebhookDeliveries.WithLabelValues(event.Event, "dropped").Inc()
+			continue
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Error(err, "error marshaling webhook event", "event", event.Event)
+			continue
+		}
+
+		result := "failure"
+		backoff := d.backoff
+	retry:
+		for attempt := 0; attempt <= d.maxRetries; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-time.After(backoff):
+				case <-d.ctx.Done():
+					result = "dropped"
+					break retry
+				}
+				backoff *= 2
+				if backoff > webhookBackoffMax {
+					backoff = webhookBackoffMax
+				}
+			}
+
+			if err = d.deliver(e.url, event, body); err == nil {
+				result = "success"
+				break
+			}
+			log.Error(err, "webhook delivery failed", "url", e.url, "event", event.Event, "attempt", attempt)
+		}
+		prometheusCounterWebhookDeliveries.WithLabelValues(event.Event, result).Inc()
+	}
+}
+
+func (d *webhookDispatcher) deliver(url string, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ion-Event", event.Event)
+	req.Header.Set("X-Ion-Delivery", event.ID)
+	if len(d.secret) > 0 {
+		// Signed per attempt so retries carry a fresh timestamp
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(d.secret, timestamp, body))
+	}
+
+	start := time.Now()
+	res, err := d.client.Do(req)
+	prometheusHistogramWebhookLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook receiver returned %v", res.Status)
+	}
+	return nil
+}
+
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a delivery's signature and that it was signed within tolerance of now,
+// for receivers written in go
+func VerifyWebhook(secret []byte, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(WebhookTimestampHeader)
+	signed, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+	if age := time.Since(time.Unix(signed, 0)); age > tolerance || age < -tolerance {
+		return errWebhookTimestamp
+	}
+
+	expected := webhookSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(WebhookSignatureHeader))) {
+		return errWebhookSignature
+	}
+	return nil
+}